@@ -3,6 +3,7 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
@@ -12,7 +13,6 @@ import (
 
 	"gopkg.in/yaml.v3"
 
-	"github.com/alecthomas/jsonschema"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -28,7 +28,7 @@ var configSchemaCmd = &cobra.Command{
 	Short: "Print the jsonschema for configuration file",
 	Long:  "Print the jsonschema for configuration file",
 	Run: func(cmd *cobra.Command, args []string) {
-		schema := jsonschema.Reflect(&common.Config{})
+		schema := common.ConfigSchema()
 		jsonBytes, err := json.MarshalIndent(schema, "", "  ")
 		cobra.CheckErr(err)
 		fmt.Println(string(jsonBytes))
@@ -38,16 +38,165 @@ var configSchemaCmd = &cobra.Command{
 var configViewCmd = &cobra.Command{
 	Use:   "view",
 	Short: "Print the final configuration result",
-	Long:  "Print the final configuration after loading all the overrides and defaults",
+	Long:  "Print the final configuration after merging every config file and loading all the overrides and defaults.\nEvery import is annotated with the config file it came from.",
 	Run: func(cmd *cobra.Command, args []string) {
 		conf, err := LoadConfig()
 		cobra.CheckErr(err)
-		output, err := yaml.Marshal(conf)
+		output, err := common.MarshalConfig(conf)
 		cobra.CheckErr(err)
 		fmt.Println(string(output))
 	},
 }
 
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration file",
+	Long: `Validate the configuration file against the jsonschema from 'config schema' and compile every jq expression in it.
+Every problem is reported with the file, line and field it was found at.  Exits with code 1 if any problem was found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := readConfigFiles()
+		cobra.CheckErr(err)
+		problems := []common.ConfigError{}
+		for _, file := range files {
+			for _, problem := range common.ValidateConfig(string(file.Data), variables) {
+				problem.File = file.Name
+				problems = append(problems, problem)
+			}
+		}
+		if len(problems) == 0 {
+			if _, err = parseConfigs(files); err != nil {
+				problems = append(problems, common.ConfigError{File: files[0].Name, Line: 1, Message: err.Error()})
+			}
+		}
+		if !IsTextOutput() {
+			output, err := json.MarshalIndent(problems, "", "    ")
+			cobra.CheckErr(err)
+			fmt.Println(string(output))
+		} else if len(problems) == 0 {
+			for _, file := range files {
+				fmt.Printf("%s is valid\n", file.Name)
+			}
+		}
+		if len(problems) == 0 {
+			return
+		}
+		for _, problem := range problems {
+			log.Error().Msgf("%s:%s", problem.File, problem.Error())
+		}
+		os.Exit(1)
+	},
+}
+
+var (
+	configTestFiles []string
+	configTestTrace bool
+)
+
+var configTestCmd = &cobra.Command{
+	Use:   "test -f FILE",
+	Short: "Evaluate the configuration against kubernetes manifests",
+	Long: `Evaluate every import of the configuration against the resources of kubernetes manifest files without a cluster.
+For every resource the matching imports are shown with the result of each selector exclude and the resulting service.
+Use --trace to also show the output of every jq expression of the mapping.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := LoadConfig()
+		cobra.CheckErr(err)
+		resources, err := common.ReadManifestFiles(configTestFiles, nil, manifestNamespace)
+		cobra.CheckErr(err)
+		var results []common.EvaluateResult
+		for _, resource := range resources {
+			evaluated := common.EvaluateConfig(config, resource, configTestTrace)
+			if len(evaluated) == 0 {
+				log.Warn().Msgf("[%s] No import selects apiVersion '%v' and kind '%v'", common.ResourceName(resource), resource["apiVersion"], resource["kind"])
+			}
+			results = append(results, evaluated...)
+		}
+		if !IsTextOutput() {
+			output, err := json.MarshalIndent(results, "", "    ")
+			cobra.CheckErr(err)
+			fmt.Println(string(output))
+			return
+		}
+		for _, result := range results {
+			PrintEvaluateResult(config, result)
+		}
+	},
+}
+
+func PrintEvaluateResult(config *common.Config, result common.EvaluateResult) {
+	selector := config.Service.Import[result.Import].SelectorConfig
+	fmt.Printf("[%s] import[%d] %s/%s\n", result.Resource, result.Import, selector.ApiVersion, selector.Kind)
+	if !result.Selected {
+		fmt.Println("  not selected: namespaces or labels do not match")
+	}
+	for _, exclude := range result.Excludes {
+		if exclude.Error != "" {
+			fmt.Printf("  exclude %s -> error: %s\n", exclude.Expression, exclude.Error)
+		} else {
+			fmt.Printf("  exclude %s -> %s (matched: %t)\n", exclude.Expression, orNull(exclude.Value), exclude.Matched)
+		}
+	}
+	if result.Skipped() {
+		fmt.Println("  skipped: this resource would not be imported")
+	}
+	for _, trace := range result.Trace {
+		if trace.Error != "" {
+			fmt.Printf("  %s: %s -> error: %s\n", trace.Field, trace.Expression, trace.Error)
+		} else {
+			fmt.Printf("  %s: %s -> %s\n", trace.Field, trace.Expression, orNull(trace.Value))
+		}
+	}
+	if result.Error != "" {
+		fmt.Printf("  error: %s\n", result.Error)
+	}
+	if result.Registration != nil {
+		output, err := json.MarshalIndent(result.Registration, "  ", "    ")
+		cobra.CheckErr(err)
+		fmt.Printf("  service: %s\n", string(output))
+	}
+	fmt.Println()
+}
+
+// orNull shows the empty output of a jq expression the way jq would print it
+func orNull(value string) string {
+	if value == "" {
+		return "null"
+	}
+	return value
+}
+
+var configMigrateInPlace bool
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the configuration file to the current version",
+	Long: `Migrate the configuration file to the current version and print the result.  Older versions are also migrated
+in memory when the configuration file is loaded, this command updates the file itself so the warning goes away.
+Comments and the order of fields are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := readConfigFiles()
+		cobra.CheckErr(err)
+		for _, file := range files {
+			migrated, applied, err := common.MigrateConfig(string(file.Data))
+			cobra.CheckErr(err)
+			if len(applied) == 0 {
+				log.Info().Msgf("%s already has the current version '%s'", file.Name, common.ConfigCurrentVersion)
+			}
+			for _, migration := range applied {
+				log.Info().Msgf("%s: migrated from '%s' to '%s': %s", file.Name, migration.From, migration.To, migration.Description)
+			}
+			if configMigrateInPlace && file.Name != "<stdin>" {
+				cobra.CheckErr(os.WriteFile(file.Name, []byte(migrated), 0o644))
+				continue
+			}
+			if len(files) > 1 {
+				fmt.Printf("# %s\n", file.Name)
+			}
+			fmt.Print(migrated)
+		}
+	},
+}
+
 var configSampleCmd = &cobra.Command{
 	Use:   "sample",
 	Short: "Print a sample config file",
@@ -69,54 +218,145 @@ var configSampleCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(configCmd)
-	configCmd.AddCommand(configSchemaCmd, configViewCmd, configSampleCmd)
+	configCmd.AddCommand(configSchemaCmd, configViewCmd, configValidateCmd, configTestCmd, configMigrateCmd, configSampleCmd)
+
+	configMigrateCmd.Flags().BoolVar(&configMigrateInPlace, "in-place", false, "Write the migrated configuration back to the config file instead of printing it.")
+
+	configTestCmd.Flags().StringArrayVarP(&configTestFiles, "file", "f", nil, "A kubernetes manifest file to evaluate, '-' reads from stdin. Can be repeated.")
+	configTestCmd.Flags().BoolVar(&configTestTrace, "trace", false, "Show the output of every jq expression of the mapping.")
+	configTestCmd.Flags().StringVar(&manifestNamespace, "namespace", "default", "The namespace of kubernetes resources without one, like the output of 'helm template'.")
+	cobra.CheckErr(configTestCmd.MarkFlagRequired("file"))
 
 	configSampleCmd.Flags().Bool("simple", false, "Adjust the sample config to be less complex")
 	err := viper.BindPFlags(configSampleCmd.Flags())
 	cobra.CheckErr(err)
 }
 
-func readConfig() []byte {
-	var err error
-	var res []byte
+// configPaths returns the config files and directories of every --config, '.' is the default file in the working directory
+func configPaths() []string {
+	paths := make([]string, len(cfgFiles))
+	for i, path := range cfgFiles {
+		if path == "." {
+			path = "./opslevel-k8s.yaml"
+		}
+		paths[i] = path
+	}
+	return paths
+}
 
-	switch cfgFile {
-	case ".":
-		res, err = os.ReadFile("./opslevel-k8s.yaml")
-	case "-":
-		buf := bytes.Buffer{}
-		_, err = buf.ReadFrom(os.Stdin)
-		res = buf.Bytes()
-	default:
-		res, err = os.ReadFile(cfgFile)
+// readsStdin is true if any --config is '-'
+func readsStdin() bool {
+	for _, path := range cfgFiles {
+		if path == "-" {
+			return true
+		}
 	}
-	if err != nil {
+	return false
+}
+
+func readConfigFiles() ([]common.ConfigFile, error) {
+	var files []common.ConfigFile
+	for _, path := range configPaths() {
+		if path == "-" {
+			buf := bytes.Buffer{}
+			if _, err := buf.ReadFrom(os.Stdin); err != nil {
+				return nil, err
+			}
+			files = append(files, common.ConfigFile{Name: "<stdin>", Data: buf.Bytes()})
+			continue
+		}
+		read, err := common.ReadConfigFiles([]string{path})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, read...)
+	}
+	return files, nil
+}
+
+// readConfig reads the config files, the sample is only used when no --config was passed and the default file is missing
+func readConfig() ([]common.ConfigFile, error) {
+	res, err := readConfigFiles()
+	if err != nil && errors.Is(err, os.ErrNotExist) && !rootCmd.PersistentFlags().Changed("config") {
 		log.Warn().Err(err).Msg("could not read config file - falling back to default")
-		return []byte(common.ConfigSample)
+		return []common.ConfigFile{{Name: "<sample>", Data: []byte(common.ConfigSample)}}, nil
 	}
-	return res
+	return res, err
 }
 
 func LoadConfig() (*common.Config, error) {
+	files, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigs(files)
+}
+
+// parseConfigs parses every config file and merges their imports into a single config
+func parseConfigs(files []common.ConfigFile) (*common.Config, error) {
 	var (
-		config      *common.Config
-		configBytes []byte
-		err         error
-		help        = "Please update the config file or create a new one with a sample from `kubectl opslevel config sample`"
+		names   = make([]string, len(files))
+		configs = make([]*common.Config, len(files))
+		err     error
+	)
+	for i, file := range files {
+		names[i] = file.Name
+		if configs[i], err = parseConfig(file.Name, file.Data); err != nil {
+			if len(files) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+	config, err := common.MergeConfigs(names, configs)
+	if err != nil {
+		return nil, err
+	}
+	// the sections are validated together again since they may come from different files
+	if err = config.Service.EmptyValues.Validate(config.Service.Conflicts); err != nil {
+		return nil, err
+	}
+	config.BindVariables(variables)
+	return config, nil
+}
+
+func parseConfig(name string, configBytes []byte) (*common.Config, error) {
+	var (
+		config *common.Config
+		err    error
+		help   = "Please update the config file or create a new one with a sample from `kubectl opslevel config sample`"
 	)
-	configBytes = readConfig()
 	if len(configBytes) == 0 {
 		return nil, fmt.Errorf("the config file is empty | %s", help)
 	}
-	config, err = common.ParseConfig(string(configBytes))
+	migrated, applied, err := common.MigrateConfig(string(configBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%v | %s", err, help)
+	}
+	if len(applied) > 0 {
+		log.Warn().Msgf("migrated the config file '%s' from version '%s' to '%s' in memory - run `kubectl opslevel config migrate` to update the file",
+			name, applied[0].From, common.ConfigCurrentVersion)
+	}
+	config, err = common.ParseConfig(migrated)
 	if err != nil {
 		return nil, err
 	}
-	if config.Version == "" {
-		return nil, fmt.Errorf("could not parse version in the config file | %s", help)
-	} else if config.Version != common.ConfigCurrentVersion {
-		return nil, fmt.Errorf("supported config version is '%s' but found '%s' | %s",
-			common.ConfigCurrentVersion, config.Version, help)
+	if err = config.Service.Conflicts.Validate(); err != nil {
+		return nil, fmt.Errorf("%v | %s", err, help)
+	}
+	if err = config.Service.Aliases.Managed.Validate(); err != nil {
+		return nil, fmt.Errorf("%v | %s", err, help)
+	}
+	if err = config.Service.Repositories.Match.Validate(); err != nil {
+		return nil, fmt.Errorf("%v | %s", err, help)
+	}
+	if err = config.Service.EmptyValues.Validate(config.Service.Conflicts); err != nil {
+		return nil, fmt.Errorf("%v | %s", err, help)
+	}
+	for i, importConfig := range config.Service.Import {
+		if err = importConfig.Manage.Validate(); err != nil {
+			return nil, fmt.Errorf("service.import[%d].manage: %v | %s", i, err, help)
+		}
 	}
 	return config, nil
 }
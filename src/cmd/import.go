@@ -2,31 +2,97 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"os"
 
 	"github.com/opslevel/kubectl-opslevel/common"
-	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+// importPartialFailureExitCode is returned when at least one service had a failure during import
+const importPartialFailureExitCode = 2
+
+var (
+	importReportFile   string
+	importReportFormat string
+)
+
 var importCmd = &cobra.Command{
 	Use:   "import",
 	Short: "Create or Update service entries in OpsLevel",
-	Long:  `This command will take the data found in your Kubernetes cluster and begin to reconcile it with OpsLevel`,
+	Long: `This command will take the data found in your Kubernetes cluster and begin to reconcile it with OpsLevel
+
+Exits with code 2 if any service or any of its aliases, tags, tools, repositories or properties failed to reconcile.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if importReportFormat != "json" && importReportFormat != "junit" {
+			cobra.CheckErr(fmt.Errorf("unknown report format '%s' - must be one of [json, junit]", importReportFormat))
+		}
 		config, err := LoadConfig()
 		cobra.CheckErr(err)
 
-		queue := make(chan opslevel_jq_parser.ServiceRegistration, 1)
-		ctx := common.InitSignalHandler(context.Background(), queue)
+		queue := make(chan common.SourcedRegistration, 1)
+		ctx := common.InitSignalHandler(context.Background())
 		client := createOpslevelClient()
 		common.SyncCache(client)
-		common.SetupControllers(ctx, config, queue, 0)
-		common.ReconcileServices(client, disableServiceCreation, enableServiceNameUpdate, queue)
+		// the registrations are only reconciled once the queue closed, so the index holds all of them
+		index := common.NewRegistrationIndex()
+		setupSources(ctx, config, queue, index)
+		report := common.NewReconcileReport()
+		coalescer := common.NewCoalescer(config.Service.Conflicts)
+		common.ReconcileServices(createServiceReconciler(client, config, index), concurrency, coalescer.Run(queue, 0), report, nil)
+		report.AddConflicts(coalescer.Conflicts()...)
 		log.Info().Msg("Import Complete")
+
+		PrintReport(IsTextOutput(), report)
+		if importReportFile != "" {
+			cobra.CheckErr(writeReport(importReportFile, importReportFormat, report))
+		}
+		if failures := report.Failures(); failures > 0 {
+			log.Error().Msgf("%d of %d services had failures during import", failures, len(report.Results))
+			os.Exit(importPartialFailureExitCode)
+		}
 	},
 }
 
 func init() {
 	serviceCmd.AddCommand(importCmd)
+	addManifestFlags(importCmd)
+	importCmd.Flags().StringVar(&importReportFile, "report-file", "", "Write a report of every reconciled service to this file.")
+	importCmd.Flags().StringVar(&importReportFormat, "report-format", "json", "The format of the report file. One of: json|junit")
+}
+
+func PrintReport(isTextOutput bool, report *common.ReconcileReport) {
+	if !isTextOutput {
+		output, err := report.JSON()
+		cobra.CheckErr(err)
+		fmt.Println(string(output))
+		return
+	}
+	summary := report.Summary()
+	fmt.Printf("Import: %d created, %d updated, %d unchanged, %d skipped, %d failed.\n",
+		summary[common.ReconcileActionCreated], summary[common.ReconcileActionUpdated], summary[common.ReconcileActionUnchanged],
+		summary[common.ReconcileActionSkipped], summary[common.ReconcileActionFailed])
+	if failures := report.Failures(); failures > 0 {
+		fmt.Printf("%d services had failures, rerun with '-o json' or '--report-file' for details.\n", failures)
+	}
+	if conflicts := len(report.Conflicts); conflicts > 0 {
+		fmt.Printf("%d fields had conflicting values across merged services, rerun with '-o json' or '--report-file' for details.\n", conflicts)
+	}
+}
+
+func writeReport(path, format string, report *common.ReconcileReport) error {
+	var (
+		output []byte
+		err    error
+	)
+	if format == "junit" {
+		output, err = report.JUnit()
+	} else {
+		output, err = report.JSON()
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, output, 0o644)
 }
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes an import would make in OpsLevel",
+	Long: `This command will reconcile the data found in your Kubernetes cluster against OpsLevel without writing anything.
+Every create, update, alias, tag, tool, repository and property change that 'service import' would make is printed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := LoadConfig()
+		cobra.CheckErr(err)
+
+		queue := make(chan common.SourcedRegistration, 1)
+		ctx := common.InitSignalHandler(context.Background())
+		client := createOpslevelClient()
+		common.SyncCache(client)
+		// the registrations are only planned once the queue closed, so the index holds all of them
+		index := common.NewRegistrationIndex()
+		setupSources(ctx, config, queue, index)
+		coalescer := common.NewCoalescer(config.Service.Conflicts)
+		plan := common.PlanServices(createServiceReconciler(client, config, index), concurrency, coalescer.Run(queue, 0))
+		plan.Conflicts = coalescer.Conflicts()
+		PrintPlan(IsTextOutput(), plan)
+	},
+}
+
+func init() {
+	serviceCmd.AddCommand(planCmd)
+	addManifestFlags(planCmd)
+}
+
+func PrintPlan(isTextOutput bool, plan *common.Plan) {
+	if !isTextOutput {
+		prettyJSON, err := json.MarshalIndent(plan, "", "    ")
+		cobra.CheckErr(err)
+		fmt.Println(string(prettyJSON))
+		return
+	}
+
+	if len(plan.Changes) == 0 {
+		fmt.Println("No changes. OpsLevel is up to date with your Kubernetes cluster.")
+		PrintConflicts(isTextOutput, plan.Conflicts)
+		return
+	}
+	fmt.Print("The following changes would be made in OpsLevel ...\n\n")
+	service := ""
+	for i, change := range plan.Changes {
+		if i == 0 || change.Service != service {
+			service = change.Service
+			fmt.Printf("[%s]\n", service)
+		}
+		input, err := json.Marshal(change.Input)
+		cobra.CheckErr(err)
+		fmt.Printf("  %s: %s\n", change.Action, string(input))
+	}
+	fmt.Printf("\nPlan: %d changes to %d services.\n", len(plan.Changes), plan.Services())
+	PrintConflicts(isTextOutput, plan.Conflicts)
+	fmt.Println("\nIf you're happy with the above changes you can apply them by running:\n\n OPSLEVEL_API_TOKEN=XXX kubectl opslevel service import")
+}
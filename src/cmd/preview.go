@@ -33,22 +33,24 @@ If the optional argument SAMPLES_COUNT=0 this will print out everything.`,
 		config, err := LoadConfig()
 		cobra.CheckErr(err)
 
-		queue := make(chan opslevel_jq_parser.ServiceRegistration, 1)
-		ctx := common.InitSignalHandler(context.Background(), queue)
+		queue := make(chan common.SourcedRegistration, 1)
+		ctx := common.InitSignalHandler(context.Background())
 		client := createOpslevelClient()
 		common.SyncCache(client)
-		common.SetupControllers(ctx, config, queue, 0)
-		PrintServices(IsTextOutput(), sampleCount, queue)
+		setupSources(ctx, config, queue, nil)
+		coalescer := common.NewCoalescer(config.Service.Conflicts)
+		PrintServices(IsTextOutput(), sampleCount, coalescer.Run(queue, 0))
+		PrintConflicts(IsTextOutput(), coalescer.Conflicts())
 	},
 }
 
 func init() {
 	serviceCmd.AddCommand(previewCmd)
+	addManifestFlags(previewCmd)
 }
 
-func PrintServices(isTextOutput bool, samples int, queue <-chan opslevel_jq_parser.ServiceRegistration) {
+func PrintServices(isTextOutput bool, samples int, queue <-chan common.SourcedRegistration) {
 	services := common.AggregateServices(queue)
-	// Deduplicate ServiceRegistrations
 
 	// Sample the data
 	sampled := common.GetSample[opslevel_jq_parser.ServiceRegistration](samples, *services)
@@ -74,3 +76,20 @@ func PrintServices(isTextOutput bool, samples int, queue <-chan opslevel_jq_pars
 		fmt.Println("\nIf you're happy with the above data you can reconcile it with OpsLevel by running:\n\n OPSLEVEL_API_TOKEN=XXX kubectl opslevel service import\n\nOtherwise, please adjust the config file and rerun this command")
 	}
 }
+
+// PrintConflicts lists the fields which had different values across the resources merged into one service.
+// Conflicts are only logged when not using text output to keep the output valid json.
+func PrintConflicts(isTextOutput bool, conflicts []common.RegistrationConflict) {
+	if !isTextOutput || len(conflicts) == 0 {
+		return
+	}
+	fmt.Printf("\nThe following fields had conflicting values across resources merged into one service ...\n\n")
+	for _, conflict := range conflicts {
+		resolution := fmt.Sprintf("using '%s'", conflict.Chosen)
+		if conflict.Skipped {
+			resolution = "skipped"
+		}
+		fmt.Printf("  [%s] %s: %q -> %s (%s)\n", conflict.Service, conflict.Field, conflict.Values, resolution, conflict.Policy)
+	}
+	fmt.Println("\nChoose how conflicts are resolved with 'service.conflicts' in the config file.")
+}
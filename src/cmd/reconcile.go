@@ -2,15 +2,26 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"sync/atomic"
 	"time"
 
-	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
-
 	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
-var reconcileResyncInterval int
+var (
+	reconcileResyncInterval        int
+	reconcileDeletionSweepInterval int
+	reconcileMetricsAddress        string
+	reconcileLeaderElect           bool
+	reconcileRequeueAttempts       int
+	reconcileCoalesceWindow        int
+	reconcileWatchConfig           bool
+	reconcileLeaderElection        common.LeaderElection
+)
 
 var reconcileCmd = &cobra.Command{
 	Use:   "reconcile",
@@ -20,21 +31,117 @@ var reconcileCmd = &cobra.Command{
 		var (
 			err    error
 			resync = time.Hour * time.Duration(reconcileResyncInterval)
+			sweep  = time.Minute * time.Duration(reconcileDeletionSweepInterval)
 		)
 		config, err := LoadConfig()
 		cobra.CheckErr(err)
 
-		queue := make(chan opslevel_jq_parser.ServiceRegistration, 1)
-		ctx := common.InitSignalHandler(context.Background(), queue)
+		queue := make(chan common.SourcedRegistration, 1)
+		ctx := common.InitSignalHandler(context.Background())
+		// the queue is closed once the controllers and the requeuer stopped after an interruption
+		producers := common.NewQueueProducers(queue)
+		producers.CloseWhenDone(ctx)
+		// every registration of a service is merged on each event, not only the ones within the coalesce window
+		index := common.NewRegistrationIndex()
+		if reconcileLeaderElect {
+			// a standby does not drain the queue, its informers only record the registrations until it leads
+			index.Standby()
+		}
+		deletionHandlers := common.NewDeletionHandlers(index, viper.GetString("deletion-confirm-token"))
+		if reconcileMetricsAddress != "" {
+			common.ServeMetrics(ctx, reconcileMetricsAddress, deletionHandlers)
+		}
 		client := createOpslevelClient()
 		common.SyncCache(client)
 		common.SyncCaches(createOpslevelClient(), resync)
-		common.SetupControllers(ctx, config, queue, resync)
-		common.ReconcileServices(client, disableServiceCreation, enableServiceNameUpdate, queue)
+		controllers := common.SetupControllers(ctx, config, producers, index, deletionHandlers, resync)
+		reconciler := createServiceReconciler(client, config, index)
+		var (
+			current   atomic.Pointer[common.Config]
+			deletions atomic.Pointer[common.ImportRunner]
+		)
+		current.Store(config)
+		if reconcileWatchConfig && !readsStdin() && controllers != nil {
+			cobra.CheckErr(common.WatchConfigFiles(ctx, configPaths(), func(files []common.ConfigFile) {
+				reloadConfig(files, &current, controllers, &deletions)
+			}))
+		}
+		lead := func(ctx context.Context) {
+			go index.Lead(ctx, producers)
+			deletions.Store(common.SetupDeletionHandlers(ctx, current.Load(), deletionHandlers, reconciler, sweep))
+			requeuer := common.NewRequeuer(reconcileRequeueAttempts, 5*time.Second, 10*time.Minute)
+			requeuer.Run(ctx, producers)
+			coalesced := common.NewCoalescer(config.Service.Conflicts).WithIndex(index).Run(queue, time.Second*time.Duration(reconcileCoalesceWindow))
+			common.ReconcileServices(reconciler, concurrency, coalesced, nil, requeuer)
+		}
+		if reconcileLeaderElect {
+			// standbys keep their informers synced so failover only has to replay the index
+			cobra.CheckErr(common.RunLeaderElection(ctx, reconcileLeaderElection, lead))
+			return
+		}
+		lead(ctx)
 	},
 }
 
+// reloadConfig validates changed config files and restarts the controllers and deletion handlers of the imports
+// which changed.  Config files which do not validate are rejected and the previous config keeps running.
+// Only 'service.import' is reloaded, the reconciler and coalescer keep the rest of the config they were created with
+// until the next restart so the running config keeps the previous values outside of the imports.
+func reloadConfig(files []common.ConfigFile, current *atomic.Pointer[common.Config], controllers *common.ImportRunner, deletions *atomic.Pointer[common.ImportRunner]) {
+	rejected := 0
+	for _, file := range files {
+		for _, problem := range common.ValidateConfig(string(file.Data), variables) {
+			log.Error().Msgf("%s:%s", file.Name, problem.Error())
+			rejected++
+		}
+	}
+	if rejected > 0 {
+		log.Error().Msgf("rejected config change with %d problem(s) ... keeping previous config", rejected)
+		return
+	}
+	config, err := parseConfigs(files)
+	if err != nil {
+		log.Error().Err(err).Msg("rejected config change ... keeping previous config")
+		return
+	}
+	previous := current.Load()
+	applied := *previous
+	applied.Service.Import = config.Service.Import
+	current.Store(&applied)
+	stopped, started := controllers.Update(config.Service.Import)
+	if runner := deletions.Load(); runner != nil {
+		runner.Update(config.Service.Import)
+	}
+	log.Info().Msgf("reloaded config ... stopped %d and started %d import(s)", stopped, started)
+	if !sameOutsideImports(previous, config) {
+		log.Warn().Msg("changes outside of 'service.import' were not applied ... restart to apply them")
+	}
+}
+
+// sameOutsideImports is true if the configs only differ in their imports
+func sameOutsideImports(a, b *common.Config) bool {
+	strip := func(config *common.Config) string {
+		copied := *config
+		copied.Service.Import = nil
+		data, _ := json.Marshal(copied)
+		return string(data)
+	}
+	return strip(a) == strip(b)
+}
+
 func init() {
 	serviceCmd.AddCommand(reconcileCmd)
 	reconcileCmd.Flags().IntVar(&reconcileResyncInterval, "resync", 24, "The amount (in hours) before a full resync of the kubernetes cluster happens with OpsLevel.")
+	reconcileCmd.Flags().IntVar(&reconcileDeletionSweepInterval, "deletion-sweep", 5, "The amount (in minutes) between retries of deletions held back by an 'onDelete' threshold.")
+	reconcileCmd.Flags().StringVar(&reconcileMetricsAddress, "metrics-address", "", "The address (e.g. ':8080') to serve '/healthz', '/readyz', '/metrics' and '/deletions/confirm' on. Disabled when empty.")
+	reconcileCmd.Flags().String("deletion-confirm-token", "", "The bearer token to confirm deletions held back by an 'onDelete' threshold with on '/deletions/confirm'. Overrides environment variable 'OPSLEVEL_DELETION_CONFIRM_TOKEN'. Confirmation is disabled when empty.")
+	cobra.CheckErr(viper.BindPFlag("deletion-confirm-token", reconcileCmd.Flags().Lookup("deletion-confirm-token")))
+	cobra.CheckErr(viper.BindEnv("deletion-confirm-token", "OPSLEVEL_DELETION_CONFIRM_TOKEN"))
+	reconcileCmd.Flags().IntVar(&reconcileRequeueAttempts, "requeue-attempts", 5, "The number of attempts for a service which failed to reconcile before waiting for the next resync. Retries back off exponentially from 5 seconds up to 10 minutes. 1 disables requeuing.")
+	reconcileCmd.Flags().IntVar(&reconcileCoalesceWindow, "coalesce-window", 5, "The amount (in seconds) to wait for more kubernetes events before services sharing an alias are merged and reconciled, at most 10 times the window after the first event.")
+	reconcileCmd.Flags().BoolVar(&reconcileWatchConfig, "watch-config", true, "Watch the config file and restart the controllers of the imports which changed. Changes outside 'service.import' require a restart.")
+	reconcileCmd.Flags().BoolVar(&reconcileLeaderElect, "leader-elect", false, "Use a kubernetes Lease to elect a single leader when running multiple replicas, standbys only reconcile after taking over the lease.")
+	reconcileCmd.Flags().StringVar(&reconcileLeaderElection.Namespace, "leader-election-namespace", common.DefaultLeaderElectionNamespace(), "The namespace of the leader election Lease.")
+	reconcileCmd.Flags().StringVar(&reconcileLeaderElection.Name, "leader-election-id", "kubectl-opslevel", "The name of the leader election Lease.")
+	reconcileCmd.Flags().StringVar(&reconcileLeaderElection.Identity, "leader-election-identity", common.DefaultLeaderElectionIdentity(), "The identity of this replica in the leader election Lease.")
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/opslevel/kubectl-opslevel/common"
 	"github.com/opslevel/opslevel-go/v2024"
 	"github.com/spf13/cobra"
 
@@ -22,7 +23,9 @@ var (
 	apiToken                string
 	apiTokenFile            string
 	apiTimeout              int
-	cfgFile                 string
+	cfgFiles                []string
+	variablePairs           []string
+	variables               map[string]string
 	concurrency             int
 	outputFormat            string
 	disableServiceCreation  bool
@@ -43,13 +46,15 @@ func Execute(c string, v string) {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "./opslevel-k8s.yaml", "")
+	rootCmd.PersistentFlags().StringArrayVarP(&cfgFiles, "config", "c", []string{"./opslevel-k8s.yaml"}, "The config file, a directory of '.yaml' config files or '-' for stdin. Can be repeated to merge the imports of multiple config files.")
+	rootCmd.PersistentFlags().StringArrayVar(&variablePairs, "var", nil, "A 'name=value' variable available as the string '$name' in every jq expression of the config file. Can be repeated.")
 	rootCmd.PersistentFlags().String("log-format", "TEXT", "overrides environment variable 'OPSLEVEL_LOG_FORMAT' (options [\"JSON\", \"TEXT\"])")
 	rootCmd.PersistentFlags().String("log-level", "INFO", "overrides environment variable 'OPSLEVEL_LOG_LEVEL' (options [\"ERROR\", \"WARN\", \"INFO\", \"DEBUG\"])")
 	rootCmd.PersistentFlags().StringVar(&apiToken, "api-token", "", "The OpsLevel API Token. Overrides environment variable 'OPSLEVEL_API_TOKEN' and the argument 'api-token-path'")
 	rootCmd.PersistentFlags().StringVar(&apiTokenFile, "api-token-path", "", "Absolute path to a file containing the OpsLevel API Token. Overrides environment variable 'OPSLEVEL_API_TOKEN'")
 	rootCmd.PersistentFlags().String("api-url", "https://app.opslevel.com/", "The OpsLevel API Url. Overrides environment variable 'OPSLEVEL_API_URL'")
 	rootCmd.PersistentFlags().IntVar(&apiTimeout, "api-timeout", 40, "The OpsLevel API timeout in seconds. Overrides environment variable 'OPSLEVEL_API_TIMEOUT'")
+	rootCmd.PersistentFlags().Int("api-attempts", 3, "The number of attempts for every OpsLevel API call that fails with a connection error, 429 or 5xx response, with exponential backoff honouring Retry-After. Creates are attempted once. 1 disables retries. Overrides environment variable 'OPSLEVEL_API_ATTEMPTS'")
 	rootCmd.PersistentFlags().IntP("workers", "w", -1, "Sets the number of workers for API call processing. -1 == # CPU cores (cgroup aware). Overrides environment variable 'OPSLEVEL_WORKERS'")
 	rootCmd.PersistentFlags().StringP("output", "o", "text", "Output format.  One of: json|text")
 	rootCmd.PersistentFlags().Bool("disable-service-create", false, "Turns off automatic service creation (service data will still be reconciled). Overrides environment variable 'OPSLEVEL_DISABLE_SERVICE_CREATE'.")
@@ -61,6 +66,7 @@ func init() {
 	cobra.CheckErr(viper.BindEnv("api-url", "OPSLEVEL_API_URL", "OL_API_URL", "OL_APIURL", "OPSLEVEL_APP_URL", "OL_APP_URL"))
 	cobra.CheckErr(viper.BindEnv("api-token", "OPSLEVEL_API_TOKEN", "OL_API_TOKEN", "OL_APITOKEN"))
 	cobra.CheckErr(viper.BindEnv("api-timeout", "OPSLEVEL_API_TIMEOUT"))
+	cobra.CheckErr(viper.BindEnv("api-attempts", "OPSLEVEL_API_ATTEMPTS"))
 	cobra.CheckErr(viper.BindEnv("workers", "OPSLEVEL_WORKERS", "OL_WORKERS"))
 	cobra.CheckErr(viper.BindEnv("disable-service-create", "OPSLEVEL_DISABLE_SERVICE_CREATE", "OL_DISABLE_SERVICE_CREATE"))
 	cobra.OnInitialize(func() {
@@ -69,6 +75,7 @@ func init() {
 		setupOutput()
 		setupConcurrency()
 		setupAPIToken()
+		setupVariables()
 		disableServiceCreation = viper.GetBool("disable-service-create")
 		if disableServiceCreation {
 			log.Info().Msgf("Service creation is disabled.")
@@ -113,6 +120,12 @@ func setupOutput() {
 	}
 }
 
+func setupVariables() {
+	var err error
+	variables, err = common.ParseVariables(variablePairs)
+	cobra.CheckErr(err)
+}
+
 func IsTextOutput() bool {
 	return outputFormat == "text"
 }
@@ -154,14 +167,20 @@ func setupAPIToken() {
 	viper.Set(key, token)
 }
 
-// createOpslevelClient creates a new OpsLevel client
+// createOpslevelClient creates a new OpsLevel client which retries failed calls up to 'api-attempts' times
 func createOpslevelClient() *opslevel.Client {
-	client := opslevel.NewGQLClient(
+	client := newOpslevelClient(max(viper.GetInt("api-attempts")-1, 0))
+	cobra.CheckErr(client.Validate())
+	return client
+}
+
+// newOpslevelClient creates a new OpsLevel client whose transport retries connection failures, 429 and 5xx responses
+func newOpslevelClient(retries int) *opslevel.Client {
+	return opslevel.NewGQLClient(
 		opslevel.SetAPIToken(viper.GetString("api-token")),
 		opslevel.SetURL(viper.GetString("api-url")),
 		opslevel.SetUserAgentExtra(fmt.Sprintf("kubectl-%s", version)),
 		opslevel.SetTimeout(time.Second*time.Duration(apiTimeout)),
+		opslevel.SetMaxRetries(retries),
 	)
-	cobra.CheckErr(client.Validate())
-	return client
 }
@@ -1,9 +1,19 @@
 package cmd
 
 import (
+	"context"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
 	"github.com/spf13/cobra"
 )
 
+var (
+	manifestFiles     []string
+	manifestDirs      []string
+	manifestNamespace string
+)
+
 var serviceCmd = &cobra.Command{
 	Use:   "service",
 	Short: "Commands for interacting with the service API",
@@ -13,3 +23,39 @@ var serviceCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(serviceCmd)
 }
+
+func createServiceReconciler(client *opslevel.Client, config *common.Config, index *common.RegistrationIndex) *common.ServiceReconciler {
+	// creates may have been applied when their response was lost so they are made through a client which does not retry
+	opslevelClient := common.NewInstrumentedOpslevelClient(common.NewCreateOnceOpslevelClient(
+		common.NewOpslevelClient(client),
+		common.NewOpslevelClient(newOpslevelClient(0)),
+	))
+	return common.NewServiceReconciler(opslevelClient, disableServiceCreation, enableServiceNameUpdate).
+		WithManagedAliases(config.Service.Aliases.Managed).
+		WithManagedTags(config.Service.Tags.Managed).
+		WithManagedTools(config.Service.Tools.Managed).
+		WithManagedRepositories(config.Service.Repositories.Managed).
+		WithRepositoryMatch(config.Service.Repositories.Match).
+		WithEmptyValues(config.Service.EmptyValues).
+		WithRegistrationIndex(index)
+}
+
+func addManifestFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&manifestFiles, "from-file", nil, "Read kubernetes resources from a multi-document YAML or JSON file instead of the cluster. Use '-' to read from stdin. Can be repeated.")
+	cmd.Flags().StringArrayVar(&manifestDirs, "from-dir", nil, "Read kubernetes resources from every .yaml, .yml or .json file in the directory instead of the cluster. Can be repeated.")
+	cmd.Flags().StringVar(&manifestNamespace, "namespace", "default", "The namespace of kubernetes resources read from files without one, like the output of 'helm template'. Built-in cluster scoped kinds are left without one.")
+}
+
+// setupSources reads the kubernetes resources from the manifest flags if any were passed, otherwise from the cluster.
+// The queue is closed once every resource was read or the context is done and the index holds every registration by then.
+func setupSources(ctx context.Context, config *common.Config, queue chan<- common.SourcedRegistration, index *common.RegistrationIndex) {
+	producers := common.NewQueueProducers(queue)
+	defer producers.Close()
+	if len(manifestFiles) == 0 && len(manifestDirs) == 0 {
+		common.SetupControllers(ctx, config, producers, index, nil, 0)
+		return
+	}
+	resources, err := common.ReadManifestFiles(manifestFiles, manifestDirs, manifestNamespace)
+	cobra.CheckErr(err)
+	common.SetupManifests(ctx, config, resources, producers, index)
+}
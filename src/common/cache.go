@@ -11,6 +11,7 @@ func SyncCache(client *opslevel.Client) {
 	opslevel.Cache.CacheTiers(client)
 	opslevel.Cache.CacheLifecycles(client)
 	opslevel.Cache.CacheTeams(client)
+	recordCacheSync()
 }
 
 // SyncCaches Runs a goroutine that will periodically sync the opslevel-go caches
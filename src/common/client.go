@@ -8,14 +8,21 @@ type OpslevelClient struct {
 	GetServiceHandler              func(alias string) (*opslevel.Service, error)
 	CreateServiceHandler           func(input opslevel.ServiceCreateInput) (*opslevel.Service, error)
 	UpdateServiceHandler           func(input opslevel.ServiceUpdateInput) (*opslevel.Service, error)
+	DeleteServiceHandler           func(id opslevel.ID) error
 	CreateAliasHandler             func(input opslevel.AliasCreateInput) error
+	DeleteAliasHandler             func(alias string) error
 	AssignTagsHandler              func(service *opslevel.Service, tags map[string]string) error
+	GetPropertiesHandler           func(service *opslevel.Service) ([]opslevel.Property, error)
 	AssignPropertyHandler          func(input opslevel.PropertyInput) error
 	CreateTagHandler               func(input opslevel.TagCreateInput) error
+	DeleteTagHandler               func(id opslevel.ID) error
 	CreateToolHandler              func(tool opslevel.ToolCreateInput) error
+	UpdateToolHandler              func(input opslevel.ToolUpdateInput) error
+	DeleteToolHandler              func(id opslevel.ID) error
 	GetRepositoryWithAliasHandler  func(alias string) (*opslevel.Repository, error)
 	CreateServiceRepositoryHandler func(input opslevel.ServiceRepositoryCreateInput) error
 	UpdateServiceRepositoryHandler func(input opslevel.ServiceRepositoryUpdateInput) error
+	DeleteServiceRepositoryHandler func(id opslevel.ID) error
 }
 
 func (c *OpslevelClient) GetService(alias string) (*opslevel.Service, error) {
@@ -39,6 +46,13 @@ func (c *OpslevelClient) UpdateService(input opslevel.ServiceUpdateInput) (*opsl
 	return c.UpdateServiceHandler(input)
 }
 
+func (c *OpslevelClient) DeleteService(id opslevel.ID) error {
+	if c.DeleteServiceHandler == nil {
+		return nil
+	}
+	return c.DeleteServiceHandler(id)
+}
+
 func (c *OpslevelClient) CreateAlias(input opslevel.AliasCreateInput) error {
 	if c.CreateAliasHandler == nil {
 		return nil
@@ -46,6 +60,13 @@ func (c *OpslevelClient) CreateAlias(input opslevel.AliasCreateInput) error {
 	return c.CreateAliasHandler(input)
 }
 
+func (c *OpslevelClient) DeleteAlias(alias string) error {
+	if c.DeleteAliasHandler == nil {
+		return nil
+	}
+	return c.DeleteAliasHandler(alias)
+}
+
 func (c *OpslevelClient) AssignTags(service *opslevel.Service, tags map[string]string) error {
 	if c.AssignTagsHandler == nil {
 		return nil
@@ -53,6 +74,13 @@ func (c *OpslevelClient) AssignTags(service *opslevel.Service, tags map[string]s
 	return c.AssignTagsHandler(service, tags)
 }
 
+func (c *OpslevelClient) GetProperties(service *opslevel.Service) ([]opslevel.Property, error) {
+	if c.GetPropertiesHandler == nil {
+		return nil, nil
+	}
+	return c.GetPropertiesHandler(service)
+}
+
 func (c *OpslevelClient) AssignProperty(input opslevel.PropertyInput) error {
 	if c.AssignPropertyHandler == nil {
 		return nil
@@ -67,6 +95,13 @@ func (c *OpslevelClient) CreateTag(input opslevel.TagCreateInput) error {
 	return c.CreateTagHandler(input)
 }
 
+func (c *OpslevelClient) DeleteTag(id opslevel.ID) error {
+	if c.DeleteTagHandler == nil {
+		return nil
+	}
+	return c.DeleteTagHandler(id)
+}
+
 func (c *OpslevelClient) CreateTool(tool opslevel.ToolCreateInput) error {
 	if c.CreateToolHandler == nil {
 		return nil
@@ -74,6 +109,20 @@ func (c *OpslevelClient) CreateTool(tool opslevel.ToolCreateInput) error {
 	return c.CreateToolHandler(tool)
 }
 
+func (c *OpslevelClient) UpdateTool(input opslevel.ToolUpdateInput) error {
+	if c.UpdateToolHandler == nil {
+		return nil
+	}
+	return c.UpdateToolHandler(input)
+}
+
+func (c *OpslevelClient) DeleteTool(id opslevel.ID) error {
+	if c.DeleteToolHandler == nil {
+		return nil
+	}
+	return c.DeleteToolHandler(id)
+}
+
 func (c *OpslevelClient) GetRepositoryWithAlias(alias string) (*opslevel.Repository, error) {
 	if c.GetRepositoryWithAliasHandler == nil {
 		return nil, nil
@@ -95,6 +144,13 @@ func (c *OpslevelClient) UpdateServiceRepository(input opslevel.ServiceRepositor
 	return c.UpdateServiceRepositoryHandler(input)
 }
 
+func (c *OpslevelClient) DeleteServiceRepository(id opslevel.ID) error {
+	if c.DeleteServiceRepositoryHandler == nil {
+		return nil
+	}
+	return c.DeleteServiceRepositoryHandler(id)
+}
+
 func NewOpslevelClient(client *opslevel.Client) *OpslevelClient {
 	return &OpslevelClient{
 		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
@@ -106,14 +162,29 @@ func NewOpslevelClient(client *opslevel.Client) *OpslevelClient {
 		UpdateServiceHandler: func(input opslevel.ServiceUpdateInput) (*opslevel.Service, error) {
 			return client.UpdateService(opslevel.ConvertServiceUpdateInput(input))
 		},
+		DeleteServiceHandler: func(id opslevel.ID) error {
+			return client.DeleteService(string(id))
+		},
 		CreateAliasHandler: func(input opslevel.AliasCreateInput) error {
 			_, err := client.CreateAlias(input)
 			return err
 		},
+		DeleteAliasHandler: func(alias string) error {
+			return client.DeleteServiceAlias(alias)
+		},
 		AssignTagsHandler: func(service *opslevel.Service, tags map[string]string) error {
 			_, err := client.AssignTags(string(service.Id), tags)
 			return err
 		},
+		GetPropertiesHandler: func(service *opslevel.Service) ([]opslevel.Property, error) {
+			// query a copy so the properties are not appended to the cached service
+			lookup := opslevel.Service{ServiceId: service.ServiceId}
+			properties, err := lookup.GetProperties(client, nil)
+			if err != nil || properties == nil {
+				return nil, err
+			}
+			return properties.Nodes, nil
+		},
 		AssignPropertyHandler: func(input opslevel.PropertyInput) error {
 			_, err := client.PropertyAssign(input)
 			return err
@@ -122,10 +193,20 @@ func NewOpslevelClient(client *opslevel.Client) *OpslevelClient {
 			_, err := client.CreateTag(input)
 			return err
 		},
+		DeleteTagHandler: func(id opslevel.ID) error {
+			return client.DeleteTag(id)
+		},
 		CreateToolHandler: func(tool opslevel.ToolCreateInput) error {
 			_, err := client.CreateTool(tool)
 			return err
 		},
+		UpdateToolHandler: func(input opslevel.ToolUpdateInput) error {
+			_, err := client.UpdateTool(input)
+			return err
+		},
+		DeleteToolHandler: func(id opslevel.ID) error {
+			return client.DeleteTool(id)
+		},
 		GetRepositoryWithAliasHandler: func(alias string) (*opslevel.Repository, error) {
 			return client.GetRepositoryWithAlias(alias)
 		},
@@ -137,5 +218,8 @@ func NewOpslevelClient(client *opslevel.Client) *OpslevelClient {
 			_, err := client.UpdateServiceRepository(input)
 			return err
 		},
+		DeleteServiceRepositoryHandler: func(id opslevel.ID) error {
+			return client.DeleteServiceRepository(id)
+		},
 	}
 }
@@ -0,0 +1,468 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rs/zerolog/log"
+)
+
+// SourcedRegistration is a registration together with the namespace of the kubernetes resource it was parsed from
+// and the fields its import manages.  Registrations merged by the coalescer have no namespace and no source.
+type SourcedRegistration struct {
+	opslevel_jq_parser.ServiceRegistration
+	Namespace string
+	Source    string // identifies the resource the registration was parsed from in a RegistrationIndex
+	Manage    ManageConfig
+}
+
+// RegistrationConflict is a field which had different values in registrations that were merged into one service
+type RegistrationConflict struct {
+	Service string         `json:"service"`
+	Field   string         `json:"field"`
+	Values  []string       `json:"values"`
+	Policy  ConflictPolicy `json:"policy"`
+	Chosen  string         `json:"chosen,omitempty"`
+	Skipped bool           `json:"skipped,omitempty"`
+}
+
+// Coalescer merges registrations which share any alias into a single registration before they are reconciled.
+// Aliases, tags, tools and repositories are unioned, every single valued field is resolved with the policy of the
+// conflicts config when the merged registrations have different values for it.
+type Coalescer struct {
+	config    ConflictsConfig
+	index     *RegistrationIndex
+	mutex     sync.Mutex
+	services  []string                          // services with conflicts in order of their first conflict
+	conflicts map[string][]RegistrationConflict // the conflicts of the latest merge of every service
+}
+
+func NewCoalescer(config ConflictsConfig) *Coalescer {
+	return &Coalescer{config: config, conflicts: map[string][]RegistrationConflict{}}
+}
+
+// WithIndex merges every registration with all indexed registrations sharing an alias with it, not only with the ones
+// of the same batch, so a single changed resource of a service is reconciled together with the unchanged ones.
+func (c *Coalescer) WithIndex(index *RegistrationIndex) *Coalescer {
+	c.index = index
+	return c
+}
+
+// Conflicts returns the conflicts of every service found by the latest merge of the service
+func (c *Coalescer) Conflicts() []RegistrationConflict {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var conflicts []RegistrationConflict
+	for _, service := range c.services {
+		conflicts = append(conflicts, c.conflicts[service]...)
+	}
+	return conflicts
+}
+
+// Coalesce groups the registrations by shared aliases and merges every group in order of first appearance.
+// A merged registration only manages a field as much as every import it came from allows.
+func (c *Coalescer) Coalesce(registrations []SourcedRegistration) []SourcedRegistration {
+	registrations, batched := c.withIndexed(registrations)
+	groups := groupRegistrations(registrations)
+	output := make([]SourcedRegistration, 0, len(groups))
+	for _, group := range groups {
+		if !anyBatched(group, batched) {
+			continue
+		}
+		merger := newRegistrationMerger(c.config)
+		for _, index := range group {
+			merger.add(registrations[index])
+		}
+		registration, conflicts := merger.merge()
+		output = append(output, registration)
+		c.record(merger.service(), conflicts)
+	}
+	return output
+}
+
+// withIndexed returns the batch together with every indexed registration related to it and which of them are part of
+// the batch.  Indexed registrations replace the ones of the batch with the same source since they are the latest,
+// a merged registration, like a requeued one, is replaced by the indexed registrations it was merged from.
+func (c *Coalescer) withIndexed(batch []SourcedRegistration) ([]SourcedRegistration, []bool) {
+	if c.index == nil {
+		batched := make([]bool, len(batch))
+		for i := range batched {
+			batched[i] = true
+		}
+		return batch, batched
+	}
+	var aliases []string
+	for _, registration := range batch {
+		aliases = append(aliases, registration.Aliases...)
+	}
+	registrations := c.index.Related(aliases)
+	batched := make([]bool, len(registrations))
+	sources := map[string]int{}
+	owners := map[string][]int{}
+	for i, registration := range registrations {
+		sources[registration.Source] = i
+		for _, alias := range registration.Aliases {
+			owners[alias] = append(owners[alias], i)
+		}
+	}
+	for _, registration := range batch {
+		if i, ok := sources[registration.Source]; ok && registration.Source != "" {
+			batched[i] = true
+			continue
+		}
+		replaced := false
+		if registration.Source == "" {
+			for _, alias := range registration.Aliases {
+				for _, i := range owners[alias] {
+					batched[i] = true
+					replaced = true
+				}
+			}
+		}
+		if !replaced {
+			registrations = append(registrations, registration)
+			batched = append(batched, true)
+		}
+	}
+	return registrations, batched
+}
+
+func anyBatched(group []int, batched []bool) bool {
+	for _, index := range group {
+		if batched[index] {
+			return true
+		}
+	}
+	return false
+}
+
+// record replaces the conflicts of the service, a service merged again without conflicts has none anymore
+func (c *Coalescer) record(service string, conflicts []RegistrationConflict) {
+	for _, conflict := range conflicts {
+		if conflict.Skipped {
+			log.Warn().Msgf("[%s] Conflicting values %q for '%s' ... skipping field", conflict.Service, conflict.Values, conflict.Field)
+		} else {
+			log.Warn().Msgf("[%s] Conflicting values %q for '%s' ... using '%s' (%s)", conflict.Service, conflict.Values, conflict.Field, conflict.Chosen, conflict.Policy)
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, known := c.conflicts[service]
+	switch {
+	case len(conflicts) == 0 && known:
+		delete(c.conflicts, service)
+		for i, existing := range c.services {
+			if existing == service {
+				c.services = append(c.services[:i], c.services[i+1:]...)
+				break
+			}
+		}
+	case len(conflicts) > 0 && !known:
+		c.services = append(c.services, service)
+		fallthrough
+	case len(conflicts) > 0:
+		c.conflicts[service] = conflicts
+	}
+}
+
+// coalesceMaxWindows bounds how long a batch is held back by registrations which keep arriving within the window
+const coalesceMaxWindows = 10
+
+// Run coalesces the registrations of the queue in batches.  A batch is emitted once no registration arrived for the
+// window or at the latest coalesceMaxWindows windows after its first registration, or once the queue is closed if the
+// window is 0.  The returned queue is closed after the input queue.
+func (c *Coalescer) Run(queue <-chan SourcedRegistration, window time.Duration) <-chan SourcedRegistration {
+	output := make(chan SourcedRegistration, 1)
+	go func() {
+		defer close(output)
+		var (
+			batch    []SourcedRegistration
+			deadline time.Time // when the batch is flushed even if registrations keep arriving
+			timer    = time.NewTimer(window)
+		)
+		timer.Stop()
+		flush := func() {
+			// the depth is tracked per queued registration so swap the received ones for the merged ones
+			queueDepth.Sub(float64(len(batch)))
+			for _, registration := range c.Coalesce(batch) {
+				queueDepth.Inc()
+				output <- registration
+			}
+			batch = nil
+		}
+		for {
+			select {
+			case registration, ok := <-queue:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 {
+					deadline = time.Now().Add(coalesceMaxWindows * window)
+				}
+				batch = append(batch, registration)
+				if window > 0 {
+					timer.Reset(min(window, time.Until(deadline)))
+				}
+			case <-timer.C:
+				flush()
+			}
+		}
+	}()
+	return output
+}
+
+// groupRegistrations returns the indexes of registrations connected through any shared alias
+func groupRegistrations(registrations []SourcedRegistration) [][]int {
+	parent := make([]int, len(registrations))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	owners := map[string]int{}
+	for i, registration := range registrations {
+		for _, alias := range registration.Aliases {
+			if owner, ok := owners[alias]; ok {
+				a, b := find(owner), find(i)
+				if a < b {
+					parent[b] = a
+				} else {
+					parent[a] = b
+				}
+			} else {
+				owners[alias] = i
+			}
+		}
+	}
+	var groups [][]int
+	positions := map[int]int{}
+	for i := range registrations {
+		root := find(i)
+		position, ok := positions[root]
+		if !ok {
+			position = len(groups)
+			positions[root] = position
+			groups = append(groups, nil)
+		}
+		groups[position] = append(groups[position], i)
+	}
+	return groups
+}
+
+var scalarFields = []string{"name", "description", "owner", "lifecycle", "tier", "product", "language", "framework", "system"}
+
+func scalarField(registration *opslevel_jq_parser.ServiceRegistration, field string) *string {
+	switch field {
+	case "name":
+		return &registration.Name
+	case "description":
+		return &registration.Description
+	case "owner":
+		return &registration.Owner
+	case "lifecycle":
+		return &registration.Lifecycle
+	case "tier":
+		return &registration.Tier
+	case "product":
+		return &registration.Product
+	case "language":
+		return &registration.Language
+	case "framework":
+		return &registration.Framework
+	case "system":
+		return &registration.System
+	}
+	return nil
+}
+
+type registrationMerger struct {
+	config       ConflictsConfig
+	registration opslevel_jq_parser.ServiceRegistration
+	manage       ManageConfig
+	seen         map[string]bool
+	fields       []string                        // every field with candidates in order of appearance
+	candidates   map[string][]*conflictCandidate // distinct values of every field
+	tools        map[string]opslevel.ToolCreateInput
+	repositories []opslevel.ServiceRepositoryCreateInput // one per repository and base directory
+	properties   map[string]opslevel.PropertyInput
+}
+
+func newRegistrationMerger(config ConflictsConfig) *registrationMerger {
+	return &registrationMerger{
+		config:     config,
+		seen:       map[string]bool{},
+		candidates: map[string][]*conflictCandidate{},
+		tools:      map[string]opslevel.ToolCreateInput{},
+		properties: map[string]opslevel.PropertyInput{},
+	}
+}
+
+// once returns true the first time the key is seen
+func (m *registrationMerger) once(key string) bool {
+	if m.seen[key] {
+		return false
+	}
+	m.seen[key] = true
+	return true
+}
+
+// offer adds the value of a field from a registration in the namespace and returns the key of its candidate
+func (m *registrationMerger) offer(field, value, namespace string) string {
+	rank := m.config.namespaceRank(namespace)
+	candidates, ok := m.candidates[field]
+	if !ok {
+		m.fields = append(m.fields, field)
+	}
+	for _, candidate := range candidates {
+		if candidate.value == value {
+			candidate.count++
+			candidate.rank = min(candidate.rank, rank)
+			return field + "=" + value
+		}
+	}
+	m.candidates[field] = append(candidates, &conflictCandidate{value: value, count: 1, rank: rank})
+	return field + "=" + value
+}
+
+func (m *registrationMerger) add(registration SourcedRegistration) {
+	merged := &m.registration
+	if m.once("manage") {
+		m.manage = registration.Manage
+	} else {
+		m.manage = m.manage.restrict(registration.Manage)
+	}
+	for _, alias := range registration.Aliases {
+		if m.once("alias:" + alias) {
+			merged.Aliases = append(merged.Aliases, alias)
+		}
+	}
+	for _, field := range scalarFields {
+		if value := *scalarField(&registration.ServiceRegistration, field); value != "" {
+			m.offer(field, value, registration.Namespace)
+		}
+	}
+	for _, tag := range registration.TagAssigns {
+		if m.once(fmt.Sprintf("tagAssign:%s:%s", tag.Key, tag.Value)) {
+			merged.TagAssigns = append(merged.TagAssigns, tag)
+		}
+	}
+	for _, tag := range registration.TagCreates {
+		if m.once(fmt.Sprintf("tagCreate:%s:%s", tag.Key, tag.Value)) {
+			merged.TagCreates = append(merged.TagCreates, tag)
+		}
+	}
+	// a tool is identified by its category, name and environment so only its url can conflict
+	for _, tool := range registration.Tools {
+		field := fmt.Sprintf("tools[%s/%s]", tool.Category, tool.DisplayName)
+		if tool.Environment != nil {
+			field = fmt.Sprintf("tools[%s/%s/%s]", tool.Category, tool.DisplayName, *tool.Environment)
+		}
+		key := m.offer(field, tool.Url, registration.Namespace)
+		if _, ok := m.tools[key]; !ok {
+			m.tools[key] = tool
+		}
+	}
+	// a repository is linked once per base directory so only the display name of a link can conflict
+	for _, repository := range registration.Repositories {
+		field := repositoryField(repository)
+		if m.once(field) {
+			m.repositories = append(m.repositories, repository)
+		}
+		if displayName := derefString(repository.DisplayName); displayName != "" {
+			m.offer(field, displayName, registration.Namespace)
+		}
+	}
+	for _, property := range registration.Properties {
+		field := fmt.Sprintf("properties[%s]", identifierString(property.Definition))
+		key := m.offer(field, string(property.Value), registration.Namespace)
+		if _, ok := m.properties[key]; !ok {
+			m.properties[key] = property
+		}
+	}
+}
+
+// service names the merged service in conflicts, by the first name found or by its first alias without one
+func (m *registrationMerger) service() string {
+	if names := m.candidates["name"]; len(names) > 0 {
+		return names[0].value
+	}
+	if len(m.registration.Aliases) > 0 {
+		return m.registration.Aliases[0]
+	}
+	return ""
+}
+
+// merge resolves every field and returns the merged registration with the conflicts found
+func (m *registrationMerger) merge() (SourcedRegistration, []RegistrationConflict) {
+	var (
+		merged    = m.registration
+		conflicts []RegistrationConflict
+		service   = m.service()
+	)
+	for _, field := range m.fields {
+		candidates := m.candidates[field]
+		policy := m.config.PolicyFor(field)
+		chosen := resolveConflict(policy, candidates)
+		if len(candidates) > 1 {
+			conflict := RegistrationConflict{Service: service, Field: field, Policy: policy, Skipped: chosen == nil}
+			for _, candidate := range candidates {
+				conflict.Values = append(conflict.Values, candidate.value)
+			}
+			if chosen != nil {
+				conflict.Chosen = chosen.value
+			}
+			conflicts = append(conflicts, conflict)
+		}
+		if chosen == nil {
+			continue
+		}
+		key := field + "=" + chosen.value
+		if target := scalarField(&merged, field); target != nil {
+			*target = chosen.value
+		} else if tool, ok := m.tools[key]; ok {
+			merged.Tools = append(merged.Tools, tool)
+		} else if property, ok := m.properties[key]; ok {
+			merged.Properties = append(merged.Properties, property)
+		}
+	}
+	for _, repository := range m.repositories {
+		field := repositoryField(repository)
+		repository.DisplayName = nil
+		if chosen := resolveConflict(m.config.PolicyFor(field), m.candidates[field]); chosen != nil {
+			repository.DisplayName = opslevel.RefOf(chosen.value)
+		}
+		merged.Repositories = append(merged.Repositories, repository)
+	}
+	return SourcedRegistration{ServiceRegistration: merged, Manage: m.manage}, conflicts
+}
+
+func repositoryField(repository opslevel.ServiceRepositoryCreateInput) string {
+	return fmt.Sprintf("repositories[%s:/%s]", identifierString(repository.Repository), strings.Trim(derefString(repository.BaseDirectory), "/"))
+}
+
+func derefString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func identifierString(identifier opslevel.IdentifierInput) string {
+	if identifier.Alias != nil {
+		return *identifier.Alias
+	}
+	if identifier.Id != nil {
+		return string(*identifier.Id)
+	}
+	return ""
+}
@@ -0,0 +1,353 @@
+package common_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func sourced(namespace string, registration opslevel_jq_parser.ServiceRegistration) common.SourcedRegistration {
+	return common.SourcedRegistration{ServiceRegistration: registration, Namespace: namespace}
+}
+
+func TestCoalesceMergesRegistrationsSharingAnAlias(t *testing.T) {
+	// Arrange
+	coalescer := common.NewCoalescer(common.ConflictsConfig{})
+	registrations := []common.SourcedRegistration{
+		sourced("default", opslevel_jq_parser.ServiceRegistration{
+			Name:       "web",
+			Owner:      "platform",
+			Aliases:    []string{"k8s:web-default"},
+			TagAssigns: []opslevel.TagInput{{Key: "env", Value: "dev"}},
+			Tools:      []opslevel.ToolCreateInput{{Category: opslevel.ToolCategoryLogs, DisplayName: "logs", Url: "https://logs/dev"}},
+		}),
+		sourced("default", opslevel_jq_parser.ServiceRegistration{
+			Name:    "worker",
+			Aliases: []string{"k8s:worker-default"},
+		}),
+		sourced("prod", opslevel_jq_parser.ServiceRegistration{
+			Name:       "web",
+			Owner:      "payments",
+			Aliases:    []string{"k8s:web-prod", "k8s:web-default"},
+			TagAssigns: []opslevel.TagInput{{Key: "env", Value: "dev"}, {Key: "env", Value: "prod"}},
+			Tools:      []opslevel.ToolCreateInput{{Category: opslevel.ToolCategoryLogs, DisplayName: "logs", Url: "https://logs/prod"}},
+			Repositories: []opslevel.ServiceRepositoryCreateInput{
+				{Repository: opslevel.IdentifierInput{Alias: opslevel.RefOf("github.com:org/web")}},
+			},
+		}),
+		sourced("prod", opslevel_jq_parser.ServiceRegistration{
+			Lifecycle: "generally_available",
+			Aliases:   []string{"k8s:web-prod"},
+		}),
+	}
+
+	// Act
+	services := coalescer.Coalesce(registrations)
+
+	// Assert
+	autopilot.Equals(t, 2, len(services))
+	web := services[0]
+	autopilot.Equals(t, "web", web.Name)
+	autopilot.Equals(t, "platform", web.Owner)
+	autopilot.Equals(t, "generally_available", web.Lifecycle)
+	autopilot.Equals(t, []string{"k8s:web-default", "k8s:web-prod"}, web.Aliases)
+	autopilot.Equals(t, []opslevel.TagInput{{Key: "env", Value: "dev"}, {Key: "env", Value: "prod"}}, web.TagAssigns)
+	autopilot.Equals(t, 1, len(web.Tools))
+	autopilot.Equals(t, "https://logs/dev", web.Tools[0].Url)
+	autopilot.Equals(t, 1, len(web.Repositories))
+	autopilot.Equals(t, "worker", services[1].Name)
+	autopilot.Equals(t, []common.RegistrationConflict{
+		{Service: "web", Field: "owner", Values: []string{"platform", "payments"}, Policy: common.ConflictPolicyFirstWins, Chosen: "platform"},
+		{Service: "web", Field: "tools[logs/logs]", Values: []string{"https://logs/dev", "https://logs/prod"}, Policy: common.ConflictPolicyFirstWins, Chosen: "https://logs/dev"},
+	}, coalescer.Conflicts())
+}
+
+func TestCoalesceJoinsGroupsThroughTransitiveAliases(t *testing.T) {
+	// Arrange
+	registrations := []common.SourcedRegistration{
+		sourced("", opslevel_jq_parser.ServiceRegistration{Name: "a", Aliases: []string{"a"}}),
+		sourced("", opslevel_jq_parser.ServiceRegistration{Name: "b", Aliases: []string{"b"}}),
+		sourced("", opslevel_jq_parser.ServiceRegistration{Name: "a", Aliases: []string{"a", "b"}}),
+	}
+
+	// Act
+	services := common.NewCoalescer(common.ConflictsConfig{}).Coalesce(registrations)
+
+	// Assert
+	autopilot.Equals(t, 1, len(services))
+	autopilot.Equals(t, []string{"a", "b"}, services[0].Aliases)
+}
+
+func TestCoalesceResolvesConflictsWithPolicy(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config        common.ConflictsConfig
+		expectedOwner string
+		expectedTier  string
+	}
+	registrations := []common.SourcedRegistration{
+		sourced("dev", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Owner: "dev-team", Tier: "tier_3"}),
+		sourced("staging", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Owner: "platform", Tier: "tier_3"}),
+		sourced("prod", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Owner: "platform", Tier: "tier_1"}),
+	}
+	cases := map[string]TestCase{
+		"First Wins": {
+			config:        common.ConflictsConfig{Policy: common.ConflictPolicyFirstWins},
+			expectedOwner: "dev-team",
+			expectedTier:  "tier_3",
+		},
+		"Majority": {
+			config:        common.ConflictsConfig{Policy: common.ConflictPolicyMajority},
+			expectedOwner: "platform",
+			expectedTier:  "tier_3",
+		},
+		"Namespace Priority": {
+			config:        common.ConflictsConfig{Policy: common.ConflictPolicyNamespacePriority, NamespacePriority: []string{"prod", "staging"}},
+			expectedOwner: "platform",
+			expectedTier:  "tier_1",
+		},
+		"Skip": {
+			config:        common.ConflictsConfig{Policy: common.ConflictPolicySkip},
+			expectedOwner: "",
+			expectedTier:  "",
+		},
+		"Per Field": {
+			config: common.ConflictsConfig{
+				Policy: common.ConflictPolicySkip,
+				Fields: map[string]common.ConflictPolicy{"owner": common.ConflictPolicyMajority},
+			},
+			expectedOwner: "platform",
+			expectedTier:  "",
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		services := common.NewCoalescer(test.config).Coalesce(registrations)
+		// Assert
+		autopilot.Equals(t, 1, len(services))
+		autopilot.Equals(t, "web", services[0].Name)
+		autopilot.Equals(t, test.expectedOwner, services[0].Owner)
+		autopilot.Equals(t, test.expectedTier, services[0].Tier)
+	})
+}
+
+func TestCoalesceRestrictsManagedFields(t *testing.T) {
+	// Arrange
+	registrations := []common.SourcedRegistration{
+		{
+			ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}},
+			Manage:              common.ManageConfig{Policy: common.ManagePolicyAlways, Fields: map[string]common.ManagePolicy{"description": common.ManagePolicyCreateOnly}},
+		},
+		{
+			ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}},
+			Manage:              common.ManageConfig{Policy: common.ManagePolicyAlways, Fields: map[string]common.ManagePolicy{"description": common.ManagePolicyAlways, "product": common.ManagePolicyNever}},
+		},
+		{
+			ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "worker", Aliases: []string{"worker"}},
+			Manage:              common.ManageConfig{Policy: common.ManagePolicyNever},
+		},
+	}
+
+	// Act
+	services := common.NewCoalescer(common.ConflictsConfig{}).Coalesce(registrations)
+
+	// Assert
+	autopilot.Equals(t, 2, len(services))
+	autopilot.Equals(t, common.ManagePolicyCreateOnly, services[0].Manage.PolicyFor("description"))
+	autopilot.Equals(t, common.ManagePolicyNever, services[0].Manage.PolicyFor("product"))
+	autopilot.Equals(t, common.ManagePolicyAlways, services[0].Manage.PolicyFor("owner"))
+	autopilot.Equals(t, common.ManagePolicyNever, services[1].Manage.PolicyFor("owner"))
+}
+
+func TestConflictsConfigValidate(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config common.ConflictsConfig
+		valid  bool
+	}
+	cases := map[string]TestCase{
+		"Default":                     {config: common.ConflictsConfig{Policy: common.ConflictPolicyFirstWins}, valid: true},
+		"Unknown Policy":              {config: common.ConflictsConfig{Policy: "last-wins"}, valid: false},
+		"Unknown Field Policy":        {config: common.ConflictsConfig{Policy: common.ConflictPolicySkip, Fields: map[string]common.ConflictPolicy{"owner": "random"}}, valid: false},
+		"Namespace Priority Missing":  {config: common.ConflictsConfig{Policy: common.ConflictPolicyNamespacePriority}, valid: false},
+		"Namespace Priority Provided": {config: common.ConflictsConfig{Policy: common.ConflictPolicyNamespacePriority, NamespacePriority: []string{"prod"}}, valid: true},
+		"Skip Name":                   {config: common.ConflictsConfig{Policy: common.ConflictPolicyFirstWins, Fields: map[string]common.ConflictPolicy{"name": common.ConflictPolicySkip}}, valid: false},
+		"Skip Globally":               {config: common.ConflictsConfig{Policy: common.ConflictPolicySkip}, valid: true},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		err := test.config.Validate()
+		// Assert
+		autopilot.Equals(t, test.valid, err == nil)
+	})
+}
+
+func TestConflictsConfigPolicyFor(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config   common.ConflictsConfig
+		field    string
+		expected common.ConflictPolicy
+	}
+	cases := map[string]TestCase{
+		"Default":           {config: common.ConflictsConfig{}, field: "owner", expected: common.ConflictPolicyFirstWins},
+		"Global":            {config: common.ConflictsConfig{Policy: common.ConflictPolicyMajority}, field: "owner", expected: common.ConflictPolicyMajority},
+		"Field":             {config: common.ConflictsConfig{Policy: common.ConflictPolicyMajority, Fields: map[string]common.ConflictPolicy{"tools": common.ConflictPolicySkip}}, field: "tools[logs/kibana]", expected: common.ConflictPolicySkip},
+		"Skip Globally":     {config: common.ConflictsConfig{Policy: common.ConflictPolicySkip}, field: "owner", expected: common.ConflictPolicySkip},
+		"Skip Never Names":  {config: common.ConflictsConfig{Policy: common.ConflictPolicySkip}, field: "name", expected: common.ConflictPolicyFirstWins},
+		"Majority For Name": {config: common.ConflictsConfig{Policy: common.ConflictPolicySkip, Fields: map[string]common.ConflictPolicy{"name": common.ConflictPolicyMajority}}, field: "name", expected: common.ConflictPolicyMajority},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		// Assert
+		autopilot.Equals(t, test.expected, test.config.PolicyFor(test.field))
+	})
+}
+
+func TestCoalescerRunFlushesAfterWindow(t *testing.T) {
+	// Arrange
+	queue := make(chan common.SourcedRegistration, 2)
+	output := common.NewCoalescer(common.ConflictsConfig{}).Run(queue, 10*time.Millisecond)
+
+	// Act
+	queue <- sourced("", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}})
+	queue <- sourced("", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}})
+
+	// Assert
+	select {
+	case registration := <-output:
+		autopilot.Equals(t, "web", registration.Name)
+	case <-time.After(time.Second):
+		t.Fatal("expected the batch to be flushed after the window")
+	}
+	close(queue)
+	_, open := <-output
+	autopilot.Equals(t, false, open)
+}
+
+func TestCoalescerRunFlushesAfterMaxWaitWhileRegistrationsKeepArriving(t *testing.T) {
+	// Arrange
+	window := 20 * time.Millisecond
+	queue := make(chan common.SourcedRegistration)
+	output := common.NewCoalescer(common.ConflictsConfig{}).Run(queue, window)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(queue)
+		ticker := time.NewTicker(window / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			select {
+			case <-done:
+				return
+			case queue <- sourced("", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}}):
+			}
+		}
+	}()
+	start := time.Now()
+
+	// Act
+	select {
+	case registration := <-output:
+		// Assert
+		autopilot.Equals(t, "web", registration.Name)
+		autopilot.Assert(t, time.Since(start) < time.Second, "expected the batch to be flushed after the max wait")
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch to be flushed while registrations keep arriving faster than the window")
+	}
+}
+
+func TestCoalesceUnionsRepositoriesByBaseDirectory(t *testing.T) {
+	// Arrange
+	repository := func(baseDirectory, displayName string) opslevel.ServiceRepositoryCreateInput {
+		return opslevel.ServiceRepositoryCreateInput{
+			Repository:    opslevel.IdentifierInput{Alias: opslevel.RefOf("github.com:org/mono")},
+			BaseDirectory: opslevel.RefOf(baseDirectory),
+			DisplayName:   opslevel.RefOf(displayName),
+		}
+	}
+	coalescer := common.NewCoalescer(common.ConflictsConfig{})
+	registrations := []common.SourcedRegistration{
+		sourced("default", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Repositories: []opslevel.ServiceRepositoryCreateInput{repository("/api", "api"), repository("/ui", "")}}),
+		sourced("default", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Repositories: []opslevel.ServiceRepositoryCreateInput{repository("ui", "frontend"), repository("/docs", "docs")}}),
+		sourced("default", opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Repositories: []opslevel.ServiceRepositoryCreateInput{repository("/api", "backend")}}),
+	}
+
+	// Act
+	services := coalescer.Coalesce(registrations)
+
+	// Assert
+	autopilot.Equals(t, 1, len(services))
+	autopilot.Equals(t, []opslevel.ServiceRepositoryCreateInput{repository("/api", "api"), repository("/ui", "frontend"), repository("/docs", "docs")}, services[0].Repositories)
+	autopilot.Equals(t, []common.RegistrationConflict{
+		{Service: "web", Field: "repositories[github.com:org/mono:/api]", Values: []string{"api", "backend"}, Policy: common.ConflictPolicyFirstWins, Chosen: "api"},
+	}, coalescer.Conflicts())
+}
+
+func TestCoalesceMergesWithIndexedRegistrations(t *testing.T) {
+	// Arrange
+	index := common.NewRegistrationIndex()
+	indexed := func(source string, registration opslevel_jq_parser.ServiceRegistration) common.SourcedRegistration {
+		sourced := common.SourcedRegistration{ServiceRegistration: registration, Namespace: "default", Source: source}
+		index.Set(sourced)
+		return sourced
+	}
+	indexed("1:default/web", opslevel_jq_parser.ServiceRegistration{Name: "web", Owner: "platform", Aliases: []string{"web"}, TagAssigns: []opslevel.TagInput{{Key: "env", Value: "dev"}}})
+	indexed("1:prod/web", opslevel_jq_parser.ServiceRegistration{Name: "web", Lifecycle: "generally_available", Aliases: []string{"web", "web-prod"}})
+	indexed("1:default/worker", opslevel_jq_parser.ServiceRegistration{Name: "worker", Aliases: []string{"worker"}})
+	changed := indexed("1:prod/web", opslevel_jq_parser.ServiceRegistration{Name: "web", Lifecycle: "beta", Aliases: []string{"web", "web-prod"}})
+	coalescer := common.NewCoalescer(common.ConflictsConfig{}).WithIndex(index)
+
+	// Act
+	services := coalescer.Coalesce([]common.SourcedRegistration{changed})
+	requeued := coalescer.Coalesce([]common.SourcedRegistration{{ServiceRegistration: services[0].ServiceRegistration}})
+
+	// Assert
+	autopilot.Equals(t, 1, len(services))
+	autopilot.Equals(t, "platform", services[0].Owner)
+	autopilot.Equals(t, "beta", services[0].Lifecycle)
+	autopilot.Equals(t, []string{"web", "web-prod"}, services[0].Aliases)
+	autopilot.Equals(t, []opslevel.TagInput{{Key: "env", Value: "dev"}}, services[0].TagAssigns)
+	autopilot.Equals(t, services, requeued)
+	autopilot.Equals(t, 0, len(coalescer.Conflicts()))
+}
+
+func TestCoalesceResolvesConflictsAgainstIndexedRegistrations(t *testing.T) {
+	// Arrange
+	index := common.NewRegistrationIndex()
+	indexed := func(source, namespace, tier string) common.SourcedRegistration {
+		sourced := common.SourcedRegistration{
+			ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "web", Aliases: []string{"web"}, Tier: tier},
+			Namespace:           namespace,
+			Source:              source,
+		}
+		index.Set(sourced)
+		return sourced
+	}
+	indexed("1:dev/web", "dev", "tier_3")
+	indexed("1:staging/web", "staging", "tier_3")
+	changed := indexed("1:prod/web", "prod", "tier_1")
+	coalescer := common.NewCoalescer(common.ConflictsConfig{Policy: common.ConflictPolicyMajority}).WithIndex(index)
+
+	// Act
+	conflicting := coalescer.Coalesce([]common.SourcedRegistration{changed})
+	conflicts := coalescer.Conflicts()
+	resolved := coalescer.Coalesce([]common.SourcedRegistration{indexed("1:prod/web", "prod", "tier_3")})
+
+	// Assert
+	autopilot.Equals(t, "tier_3", conflicting[0].Tier)
+	autopilot.Equals(t, []common.RegistrationConflict{
+		{Service: "web", Field: "tier", Values: []string{"tier_3", "tier_1"}, Policy: common.ConflictPolicyMajority, Chosen: "tier_3"},
+	}, conflicts)
+	autopilot.Equals(t, "tier_3", resolved[0].Tier)
+	autopilot.Equals(t, 0, len(coalescer.Conflicts()))
+}
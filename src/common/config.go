@@ -2,6 +2,11 @@ package common
 
 import (
 	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/creasty/defaults"
 	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
@@ -10,17 +15,26 @@ import (
 )
 
 type Import struct {
-	SelectorConfig opslevel_k8s_controller.K8SSelector          `yaml:"selector" json:"selector" mapstructure:"selector"`
-	OpslevelConfig opslevel_jq_parser.ServiceRegistrationConfig `yaml:"opslevel" json:"opslevel" mapstructure:"opslevel"`
+	SelectorConfig opslevel_k8s_controller.K8SSelector          `yaml:"selector" json:"selector" mapstructure:"selector" jsonschema:"required"`
+	OpslevelConfig opslevel_jq_parser.ServiceRegistrationConfig `yaml:"opslevel" json:"opslevel" mapstructure:"opslevel" jsonschema:"required"`
+	OnDelete       OnDelete                                     `yaml:"onDelete" json:"onDelete" mapstructure:"onDelete"`
+	Manage         ManageConfig                                 `yaml:"manage" json:"manage" mapstructure:"manage"`
+	Source         string                                       `yaml:"-" json:"-" mapstructure:"-"` // the config file the import came from
 }
 
 type Service struct {
-	Import []Import `json:"import"`
+	Aliases      AliasesConfig      `json:"aliases"`
+	Tags         TagsConfig         `json:"tags"`
+	Tools        ToolsConfig        `json:"tools"`
+	Repositories RepositoriesConfig `json:"repositories"`
+	Conflicts    ConflictsConfig    `json:"conflicts"`
+	EmptyValues  EmptyValuesConfig  `yaml:"emptyValues" json:"emptyValues"`
+	Import       []Import           `json:"import" jsonschema:"required"`
 }
 
 type Config struct {
-	Version string  `json:"version"`
-	Service Service `json:"service"`
+	Version string  `json:"version" jsonschema:"required"`
+	Service Service `json:"service" jsonschema:"required"`
 }
 
 var ConfigCurrentVersion = "1.3.0"
@@ -31,13 +45,158 @@ var ConfigSample string
 //go:embed configs/config_simple.yaml
 var ConfigSimple string
 
+// ParseConfig parses the configuration file after replacing every '${VAR}' with the environment variable
 func ParseConfig(data string) (*Config, error) {
-	var output Config
-	if err := yaml.Unmarshal([]byte(data), &output); err != nil {
+	var (
+		output   Config
+		document yaml.Node
+	)
+	if err := yaml.Unmarshal([]byte(data), &document); err != nil {
 		return nil, err
 	}
+	if err := InterpolateConfig(&document); err != nil {
+		return nil, err
+	}
+	if document.Kind != 0 {
+		if err := document.Decode(&output); err != nil {
+			return nil, err
+		}
+	}
 	if err := defaults.Set(&output); err != nil {
 		return nil, err
 	}
 	return &output, nil
 }
+
+// ConfigFile is the content of a single configuration file
+type ConfigFile struct {
+	Name string
+	Data []byte
+}
+
+// ReadConfigFiles reads the configuration files of the paths in order.  A directory is expanded to the '.yaml' and
+// '.yml' files directly in it in lexical order.
+func ReadConfigFiles(paths []string) ([]ConfigFile, error) {
+	var files []ConfigFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		names := []string{path}
+		if info.IsDir() {
+			if names, err = configFilesInDir(path); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range names {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, ConfigFile{Name: name, Data: data})
+		}
+	}
+	return files, nil
+}
+
+func configFilesInDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		extension := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (extension != ".yaml" && extension != ".yml") {
+			continue
+		}
+		names = append(names, filepath.Join(dir, entry.Name()))
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no '.yaml' or '.yml' config files in directory '%s'", dir)
+	}
+	return names, nil
+}
+
+// MergeConfigs merges the configs parsed from the named files into one.  The imports of every file are appended in
+// order and remember the file they came from.  Every other section of 'service' may only be set by a single file.
+func MergeConfigs(names []string, configs []*Config) (*Config, error) {
+	if len(configs) == 1 {
+		for i := range configs[0].Service.Import {
+			configs[0].Service.Import[i].Source = names[0]
+		}
+		return configs[0], nil
+	}
+	merged := &Config{Version: ConfigCurrentVersion}
+	if err := defaults.Set(merged); err != nil {
+		return nil, err
+	}
+	unset := merged.Service
+	owners := map[string]string{}
+	take := func(section string, value, zero any, name string) (bool, error) {
+		if reflect.DeepEqual(value, zero) {
+			return false, nil
+		}
+		if owner, ok := owners[section]; ok {
+			return false, fmt.Errorf("'service.%s' is set in both '%s' and '%s' - only one config file may set it", section, owner, name)
+		}
+		owners[section] = name
+		return true, nil
+	}
+	for i, config := range configs {
+		name := names[i]
+		if ok, err := take("aliases", config.Service.Aliases, unset.Aliases, name); err != nil {
+			return nil, err
+		} else if ok {
+			merged.Service.Aliases = config.Service.Aliases
+		}
+		if ok, err := take("tags", config.Service.Tags, unset.Tags, name); err != nil {
+			return nil, err
+		} else if ok {
+			merged.Service.Tags = config.Service.Tags
+		}
+		if ok, err := take("tools", config.Service.Tools, unset.Tools, name); err != nil {
+			return nil, err
+		} else if ok {
+			merged.Service.Tools = config.Service.Tools
+		}
+		if ok, err := take("repositories", config.Service.Repositories, unset.Repositories, name); err != nil {
+			return nil, err
+		} else if ok {
+			merged.Service.Repositories = config.Service.Repositories
+		}
+		if ok, err := take("conflicts", config.Service.Conflicts, unset.Conflicts, name); err != nil {
+			return nil, err
+		} else if ok {
+			merged.Service.Conflicts = config.Service.Conflicts
+		}
+		if ok, err := take("emptyValues", config.Service.EmptyValues, unset.EmptyValues, name); err != nil {
+			return nil, err
+		} else if ok {
+			merged.Service.EmptyValues = config.Service.EmptyValues
+		}
+		for _, importConfig := range config.Service.Import {
+			importConfig.Source = name
+			merged.Service.Import = append(merged.Service.Import, importConfig)
+		}
+	}
+	return merged, nil
+}
+
+// MarshalConfig returns the config as yaml with the file every import came from as a comment above it
+func MarshalConfig(config *Config) ([]byte, error) {
+	var document yaml.Node
+	if err := document.Encode(config); err != nil {
+		return nil, err
+	}
+	imports := mappingValue(mappingValue(&document, "service"), "import")
+	if imports != nil && imports.Kind == yaml.SequenceNode {
+		for i, item := range imports.Content {
+			if i < len(config.Service.Import) && config.Service.Import[i].Source != "" {
+				item.HeadComment = "source: " + config.Service.Import[i].Source
+			}
+		}
+	}
+	return yaml.Marshal(&document)
+}
@@ -1,6 +1,9 @@
 package common_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/opslevel/kubectl-opslevel/common"
@@ -15,4 +18,92 @@ func TestParseConfig(t *testing.T) {
 
 	autopilot.Equals(t, ".metadata.namespace", simple.Service.Import[0].OpslevelConfig.Owner)
 	autopilot.Equals(t, ".metadata.annotations.\"opslevel.com/owner\"", sample.Service.Import[0].OpslevelConfig.Owner)
+	autopilot.Equals(t, common.DeletePolicyIgnore, sample.Service.Import[0].OnDelete.Policy)
+	autopilot.Equals(t, 10, sample.Service.Import[0].OnDelete.Threshold)
+}
+
+func TestReadConfigFilesExpandsDirectories(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	teams := filepath.Join(dir, "teams")
+	autopilot.Ok(t, os.Mkdir(teams, 0o755))
+	autopilot.Ok(t, os.WriteFile(filepath.Join(dir, "base.yaml"), []byte("base"), 0o644))
+	autopilot.Ok(t, os.WriteFile(filepath.Join(teams, "b.yml"), []byte("b"), 0o644))
+	autopilot.Ok(t, os.WriteFile(filepath.Join(teams, "a.yaml"), []byte("a"), 0o644))
+	autopilot.Ok(t, os.WriteFile(filepath.Join(teams, "README.md"), []byte("readme"), 0o644))
+
+	// Act
+	files, err := common.ReadConfigFiles([]string{filepath.Join(dir, "base.yaml"), teams})
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []common.ConfigFile{
+		{Name: filepath.Join(dir, "base.yaml"), Data: []byte("base")},
+		{Name: filepath.Join(teams, "a.yaml"), Data: []byte("a")},
+		{Name: filepath.Join(teams, "b.yml"), Data: []byte("b")},
+	}, files)
+}
+
+func TestReadConfigFilesFailsOnEmptyDirectory(t *testing.T) {
+	// Act
+	_, err := common.ReadConfigFiles([]string{t.TempDir()})
+
+	// Assert
+	autopilot.Assert(t, err != nil, "expected an error for a directory without config files")
+}
+
+func TestMergeConfigsAppendsImportsWithSource(t *testing.T) {
+	// Arrange
+	base, err := common.ParseConfig(common.ConfigSample)
+	autopilot.Ok(t, err)
+	team, err := common.ParseConfig(common.ConfigSimple)
+	autopilot.Ok(t, err)
+
+	// Act
+	merged, err := common.MergeConfigs([]string{"base.yaml", "team.yaml"}, []*common.Config{base, team})
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, len(base.Service.Import)+len(team.Service.Import), len(merged.Service.Import))
+	autopilot.Equals(t, "base.yaml", merged.Service.Import[0].Source)
+	autopilot.Equals(t, "team.yaml", merged.Service.Import[len(merged.Service.Import)-1].Source)
+	autopilot.Equals(t, base.Service.Tags, merged.Service.Tags)
+}
+
+func TestMergeConfigsRejectsSectionSetTwice(t *testing.T) {
+	// Arrange
+	first, err := common.ParseConfig("version: \"1.3.0\"\nservice:\n  conflicts:\n    policy: skip\n")
+	autopilot.Ok(t, err)
+	second, err := common.ParseConfig("version: \"1.3.0\"\nservice:\n  conflicts:\n    policy: majority\n")
+	autopilot.Ok(t, err)
+
+	// Act
+	_, err = common.MergeConfigs([]string{"a.yaml", "b.yaml"}, []*common.Config{first, second})
+
+	// Assert
+	autopilot.Equals(t, "'service.conflicts' is set in both 'a.yaml' and 'b.yaml' - only one config file may set it", err.Error())
+}
+
+func TestParseConfigEmptyValues(t *testing.T) {
+	// Act
+	config, err := common.ParseConfig("version: \"1.3.0\"\nservice:\n  emptyValues:\n    fields:\n      product: authoritative\n")
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, common.EmptyValuePolicyIgnore, config.Service.EmptyValues.Policy)
+	autopilot.Equals(t, common.EmptyValuePolicyAuthoritative, config.Service.EmptyValues.PolicyFor("product"))
+}
+
+func TestMarshalConfigAnnotatesSources(t *testing.T) {
+	// Arrange
+	config, err := common.ParseConfig(common.ConfigSimple)
+	autopilot.Ok(t, err)
+	config.Service.Import[0].Source = "teams/a.yaml"
+
+	// Act
+	output, err := common.MarshalConfig(config)
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Assert(t, strings.Contains(string(output), "# source: teams/a.yaml\n"), "expected the source of the import as a comment")
 }
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ConflictPolicy string
+
+const (
+	ConflictPolicyFirstWins         ConflictPolicy = "first-wins"         // keep the first value found
+	ConflictPolicyMajority          ConflictPolicy = "majority"           // keep the value found in most resources, ties keep the first
+	ConflictPolicyNamespacePriority ConflictPolicy = "namespace-priority" // keep the value of the namespace listed first in namespacePriority
+	ConflictPolicySkip              ConflictPolicy = "skip"               // leave the field untouched in OpsLevel
+)
+
+func (p ConflictPolicy) Validate() error {
+	switch p {
+	case ConflictPolicyFirstWins, ConflictPolicyMajority, ConflictPolicyNamespacePriority, ConflictPolicySkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown conflict policy '%s' - must be one of [first-wins, majority, namespace-priority, skip]", p)
+	}
+}
+
+// ConflictsConfig chooses how a field is resolved when registrations merged into one service have different values for it.
+// Fields are the registration fields like 'owner' or 'tier' and 'tools', 'repositories' or 'properties' for their entries.
+type ConflictsConfig struct {
+	Policy            ConflictPolicy            `yaml:"policy" json:"policy" default:"first-wins" jsonschema:"enum=first-wins,enum=majority,enum=namespace-priority,enum=skip"`
+	Fields            map[string]ConflictPolicy `yaml:"fields" json:"fields"`
+	NamespacePriority []string                  `yaml:"namespacePriority" json:"namespacePriority"` // most important namespace first
+}
+
+func (c ConflictsConfig) Validate() error {
+	policies := []ConflictPolicy{c.Policy}
+	for _, policy := range c.Fields {
+		policies = append(policies, policy)
+	}
+	for _, policy := range policies {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		if policy == ConflictPolicyNamespacePriority && len(c.NamespacePriority) == 0 {
+			return fmt.Errorf("conflict policy 'namespace-priority' requires 'namespacePriority' to list at least one namespace")
+		}
+	}
+	if c.Fields["name"] == ConflictPolicySkip {
+		return fmt.Errorf("conflict policy 'skip' can not be used for 'name' - a service can not be reconciled without one")
+	}
+	return nil
+}
+
+// PolicyFor returns the policy of the field, entries like 'tools[logs/kibana]' use the policy of 'tools'.
+// A service always needs a name so 'name' falls back to first-wins instead of the global policy 'skip'.
+func (c ConflictsConfig) PolicyFor(field string) ConflictPolicy {
+	field, _, _ = strings.Cut(field, "[")
+	if policy, ok := c.Fields[field]; ok {
+		return policy
+	}
+	if c.Policy == "" || (c.Policy == ConflictPolicySkip && field == "name") {
+		return ConflictPolicyFirstWins
+	}
+	return c.Policy
+}
+
+func (c ConflictsConfig) namespaceRank(namespace string) int {
+	for i, prioritized := range c.NamespacePriority {
+		if prioritized == namespace {
+			return i
+		}
+	}
+	return len(c.NamespacePriority)
+}
+
+// conflictCandidate is a distinct value of a field with the number of registrations that had it
+// and the best namespace rank among them
+type conflictCandidate struct {
+	value string
+	count int
+	rank  int
+}
+
+// resolve picks the candidate according to the policy, candidates are in order of appearance.  nil means skip.
+func resolveConflict(policy ConflictPolicy, candidates []*conflictCandidate) *conflictCandidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+	chosen := candidates[0]
+	switch policy {
+	case ConflictPolicySkip:
+		if len(candidates) > 1 {
+			return nil
+		}
+	case ConflictPolicyMajority:
+		for _, candidate := range candidates[1:] {
+			if candidate.count > chosen.count {
+				chosen = candidate
+			}
+		}
+	case ConflictPolicyNamespacePriority:
+		for _, candidate := range candidates[1:] {
+			if candidate.rank < chosen.rank {
+				chosen = candidate
+			}
+		}
+	}
+	return chosen
+}
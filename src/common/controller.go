@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	opslevel_k8s_controller "github.com/opslevel/opslevel-k8s-controller/v2024"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ImportController calls its handlers for the kubernetes resources of an import like the controller of
+// opslevel-k8s-controller, but its informers run on the context they are started with and are shut down with it.
+type ImportController struct {
+	id         string
+	factory    dynamicinformer.DynamicSharedInformerFactory
+	informer   cache.SharedIndexInformer
+	filter     *opslevel_k8s_controller.K8SFilter
+	OnAdd      func(obj interface{})
+	OnUpdate   func(obj interface{})
+	OnDelete   func(obj interface{}) // called for resources which were deleted
+	OnDeselect func(obj interface{}) // called for resources which still exist but are no longer selected
+}
+
+// NewImportController returns a controller for the resources selected by the import in the current kubernetes cluster
+func NewImportController(config Import, resync time.Duration) (*ImportController, error) {
+	k8sClient, err := opslevel_k8s_controller.NewK8SClient()
+	if err != nil {
+		return nil, err
+	}
+	gvr, err := k8sClient.GetGVR(config.SelectorConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewDynamicImportController(k8sClient.Dynamic, *gvr, config, resync), nil
+}
+
+// NewDynamicImportController returns a controller for the resources selected by the import read through the client
+func NewDynamicImportController(client dynamic.Interface, gvr schema.GroupVersionResource, config Import, resync time.Duration) *ImportController {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, resync)
+	return &ImportController{
+		id:         fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource),
+		factory:    factory,
+		informer:   factory.ForResource(gvr).Informer(),
+		filter:     opslevel_k8s_controller.NewK8SFilter(config.SelectorConfig),
+		OnAdd:      func(obj interface{}) {},
+		OnUpdate:   func(obj interface{}) {},
+		OnDelete:   func(obj interface{}) {},
+		OnDeselect: func(obj interface{}) {},
+	}
+}
+
+func (c *ImportController) selects(obj interface{}) bool {
+	return c.filter.MatchesNamespace(obj) && !c.filter.MatchesFilter(obj)
+}
+
+// Start starts the informers and returns once the handlers were called for every resource which existed at the start.
+// The informers are shut down once the context is done and the returned channel is closed after they stopped, from then
+// on no handler is called anymore.  Handlers have to return once the context is done.
+func (c *ImportController) Start(ctx context.Context) (<-chan struct{}, error) {
+	registration, err := c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if c.selects(obj) {
+				c.OnAdd(obj)
+			}
+		},
+		UpdateFunc: func(old, obj interface{}) {
+			if c.selects(obj) {
+				c.OnUpdate(obj)
+			} else if c.selects(old) {
+				c.OnDeselect(obj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			c.OnDelete(obj)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	stopped := make(chan struct{})
+	c.factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		c.factory.Shutdown()
+		log.Info().Msgf("[%s] Informer is stopped", c.id)
+		close(stopped)
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), registration.HasSynced) {
+		return stopped, fmt.Errorf("[%s] informer was stopped before it synced", c.id)
+	}
+	log.Info().Msgf("[%s] Informer is ready and synced", c.id)
+	return stopped, nil
+}
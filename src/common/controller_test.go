@@ -0,0 +1,164 @@
+package common_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+var deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func deployment(namespace, name string) *unstructured.Unstructured {
+	resource := &unstructured.Unstructured{}
+	resource.SetAPIVersion("apps/v1")
+	resource.SetKind("Deployment")
+	resource.SetNamespace(namespace)
+	resource.SetName(name)
+	return resource
+}
+
+type handledResources struct {
+	mutex sync.Mutex
+	names []string
+}
+
+func (h *handledResources) handle(obj interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.names = append(h.names, obj.(*unstructured.Unstructured).GetName())
+}
+
+func (h *handledResources) get() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	names := append([]string{}, h.names...)
+	sort.Strings(names)
+	return names
+}
+
+func TestImportControllerHandlesSelectedResourcesUntilStopped(t *testing.T) {
+	// Arrange
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{deploymentsGVR: "DeploymentList"},
+		deployment("default", "web"), deployment("default", "canary"), deployment("kube-system", "dns"))
+	config := kindImport("Deployment")
+	config.SelectorConfig.Namespaces = []string{"default"}
+	config.SelectorConfig.Excludes = []string{`.metadata.name == "canary"`}
+	controller := common.NewDynamicImportController(client, deploymentsGVR, config, 0)
+	handled, deleted := &handledResources{}, &handledResources{}
+	controller.OnAdd = handled.handle
+	controller.OnUpdate = handled.handle
+	controller.OnDelete = deleted.handle
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Act
+	stopped, err := controller.Start(ctx)
+	synced := handled.get()
+	deleteErr := client.Resource(deploymentsGVR).Namespace("default").Delete(context.Background(), "web", metav1.DeleteOptions{})
+	for start := time.Now(); len(deleted.get()) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	_, createErr := client.Resource(deploymentsGVR).Namespace("default").Create(context.Background(), deployment("default", "api"), metav1.CreateOptions{})
+	time.Sleep(100 * time.Millisecond)
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, createErr)
+	autopilot.Ok(t, deleteErr)
+	autopilot.Equals(t, []string{"web"}, synced)
+	autopilot.Equals(t, []string{"web"}, deleted.get())
+	autopilot.Equals(t, []string{"web"}, handled.get())
+}
+
+func TestImportControllerTellsDeselectedFromDeletedResources(t *testing.T) {
+	// Arrange
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{deploymentsGVR: "DeploymentList"},
+		deployment("default", "web"), deployment("default", "api"))
+	config := kindImport("Deployment")
+	config.SelectorConfig.Excludes = []string{`.metadata.labels.skip == "true"`}
+	controller := common.NewDynamicImportController(client, deploymentsGVR, config, 0)
+	deleted, deselected := &handledResources{}, &handledResources{}
+	controller.OnDelete = deleted.handle
+	controller.OnDeselect = deselected.handle
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Act
+	_, err := controller.Start(ctx)
+	excluded := deployment("default", "web")
+	excluded.SetLabels(map[string]string{"skip": "true"})
+	_, updateErr := client.Resource(deploymentsGVR).Namespace("default").Update(context.Background(), excluded, metav1.UpdateOptions{})
+	deleteErr := client.Resource(deploymentsGVR).Namespace("default").Delete(context.Background(), "api", metav1.DeleteOptions{})
+	for start := time.Now(); (len(deleted.get()) == 0 || len(deselected.get()) == 0) && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Ok(t, updateErr)
+	autopilot.Ok(t, deleteErr)
+	autopilot.Equals(t, []string{"api"}, deleted.get())
+	autopilot.Equals(t, []string{"web"}, deselected.get())
+}
+
+func TestStandbySyncsWithFullQueueAndReplaysOnLead(t *testing.T) {
+	// Arrange
+	config, err := common.ParseConfig(`
+version: "1.3.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+      opslevel:
+        name: .metadata.name
+        aliases:
+          - '"k8s:\(.metadata.name)"'
+`)
+	autopilot.Ok(t, err)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{deploymentsGVR: "DeploymentList"},
+		deployment("default", "web"), deployment("default", "api"))
+	queue := make(chan common.SourcedRegistration, 1)
+	queue <- common.SourcedRegistration{} // nothing drains the queue of a standby
+	producers := common.NewQueueProducers(queue)
+	index := common.NewRegistrationIndex()
+	index.Standby()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controller := common.NewDynamicImportController(client, deploymentsGVR, config.Service.Import[0], 0)
+	handler := common.NewIndexedParserHandler(ctx, config.Service.Import[0], queue, index, "0:")
+	controller.OnAdd = handler
+	controller.OnUpdate = handler
+
+	// Act
+	syncCtx, syncCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer syncCancel()
+	_, syncErr := controller.Start(syncCtx)
+	recorded := len(index.Related([]string{"k8s:web", "k8s:api"}))
+	<-queue
+	go index.Lead(ctx, producers)
+	replayed := []string{}
+	for len(replayed) < 2 {
+		replayed = append(replayed, (<-queue).Name)
+	}
+	sort.Strings(replayed)
+
+	// Assert
+	autopilot.Ok(t, syncErr)
+	autopilot.Equals(t, 2, recorded)
+	autopilot.Equals(t, []string{"api", "web"}, replayed)
+}
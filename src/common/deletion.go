@@ -0,0 +1,370 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rs/zerolog/log"
+)
+
+type DeletePolicy string
+
+const (
+	DeletePolicyIgnore DeletePolicy = "ignore" // leave the service untouched
+	DeletePolicyDetach DeletePolicy = "detach" // remove the aliases generated from the kubernetes resource
+	DeletePolicyTag    DeletePolicy = "tag"    // assign the tag 'k8s-deleted: true' to the service
+	DeletePolicyDelete DeletePolicy = "delete" // delete the service
+)
+
+const DeletedTagKey = "k8s-deleted"
+
+type OnDelete struct {
+	Policy    DeletePolicy `yaml:"policy" json:"policy" default:"ignore" jsonschema:"enum=ignore,enum=detach,enum=tag,enum=delete"`
+	Threshold int          `yaml:"threshold" json:"threshold" default:"10"` // maximum number of services acted on per hour, -1 disables the limit
+}
+
+func (o OnDelete) Validate() error {
+	switch o.Policy {
+	case DeletePolicyIgnore, DeletePolicyDetach, DeletePolicyTag, DeletePolicyDelete:
+		return nil
+	default:
+		return fmt.Errorf("unknown onDelete policy '%s' - must be one of [ignore, detach, tag, delete]", o.Policy)
+	}
+}
+
+// HandleDeletion applies the policy to the service matching the registration of a kubernetes resource that was deleted
+func (r *ServiceReconciler) HandleDeletion(registration opslevel_jq_parser.ServiceRegistration, policy DeletePolicy) error {
+	if policy == DeletePolicyIgnore {
+		return nil
+	}
+	if len(registration.Aliases) <= 0 {
+		return fmt.Errorf("[%s] found 0 aliases from kubernetes data", registration.Name)
+	}
+	service, status, _ := r.lookupService(registration)
+	switch status {
+	case serviceAliasesResult_NoAliasesMatched:
+		log.Info().Msgf("[%s] No service found for deleted kubernetes resource ... skipping", registration.Name)
+		return nil
+	case serviceAliasesResult_AliasMatched:
+	case serviceAliasesResult_MultipleServicesFound:
+		return fmt.Errorf("[%s] found multiple services for deleted kubernetes resource.  cannot know which service to target ... skipping deletion", registration.Name)
+	default:
+		return fmt.Errorf("[%s] api error during service lookup by alias.  unable to guarantee service was found or not ... skipping deletion", registration.Name)
+	}
+
+	switch policy {
+	case DeletePolicyDetach:
+		for _, alias := range registration.Aliases {
+			if !service.HasAlias(alias) {
+				continue
+			}
+			if err := r.client.DeleteAlias(alias); err != nil {
+				log.Error().Msgf("[%s] Failed removing alias '%s'\n\tREASON: %v", service.Name, alias, err.Error())
+				continue
+			}
+			log.Info().Msgf("[%s] Removed alias '%s' of deleted kubernetes resource", service.Name, alias)
+		}
+	case DeletePolicyTag:
+		if err := r.client.AssignTags(service, map[string]string{DeletedTagKey: "true"}); err != nil {
+			return fmt.Errorf("[%s] Failed assigning tag '%s'\n\tREASON: %v", service.Name, DeletedTagKey, err.Error())
+		}
+		log.Info().Msgf("[%s] Tagged service with '%s' because kubernetes resource was deleted", service.Name, DeletedTagKey)
+	case DeletePolicyDelete:
+		if err := r.client.DeleteService(service.Id); err != nil {
+			return fmt.Errorf("[%s] Failed deleting service\n\tREASON: %v", service.Name, err.Error())
+		}
+		log.Info().Msgf("[%s] Deleted service because kubernetes resource was deleted", service.Name)
+	}
+	return nil
+}
+
+// deletionGuard limits the number of deletions that can happen within a rolling window
+type deletionGuard struct {
+	mutex     sync.Mutex
+	threshold int
+	window    time.Duration
+	history   []time.Time
+}
+
+func newDeletionGuard(threshold int, window time.Duration) *deletionGuard {
+	return &deletionGuard{threshold: threshold, window: window}
+}
+
+// Allow reports if `count` more deletions can happen without crossing the threshold and records them if so
+func (g *deletionGuard) Allow(now time.Time, count int) bool {
+	if g.threshold < 0 {
+		return true
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	recent := g.history[:0]
+	for _, t := range g.history {
+		if now.Sub(t) < g.window {
+			recent = append(recent, t)
+		}
+	}
+	g.history = recent
+	if len(g.history)+count > g.threshold {
+		return false
+	}
+	g.record(now, count)
+	return true
+}
+
+// Record records `count` deletions which happen regardless of the threshold, like confirmed ones
+func (g *deletionGuard) Record(now time.Time, count int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.record(now, count)
+}
+
+func (g *deletionGuard) record(now time.Time, count int) {
+	for i := 0; i < count; i++ {
+		g.history = append(g.history, now)
+	}
+}
+
+// DeletionHandlers hands the kubernetes resources deleted from the informers of an import to the deletion handler of the
+// import and lets an operator confirm the deletions a handler holds back.  Deletions seen while no handler runs for the
+// import, like on a standby, are dropped.
+type DeletionHandlers struct {
+	mutex    sync.Mutex
+	index    *RegistrationIndex
+	token    string
+	handlers map[string]*DeletionHandler // by import key
+}
+
+// NewDeletionHandlers returns the handlers of the imports, the index holds the registrations of the remaining resources.
+// Held back deletions can only be confirmed with the token, an empty token disables confirmation.
+func NewDeletionHandlers(index *RegistrationIndex, token string) *DeletionHandlers {
+	return &DeletionHandlers{index: index, token: token, handlers: map[string]*DeletionHandler{}}
+}
+
+func (d *DeletionHandlers) register(key string, handler *DeletionHandler) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.handlers[key] = handler
+}
+
+func (d *DeletionHandlers) unregister(key string, handler *DeletionHandler) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.handlers[key] == handler {
+		delete(d.handlers, key)
+	}
+}
+
+func (d *DeletionHandlers) handler(key string) *DeletionHandler {
+	if d == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.handlers[key]
+}
+
+// Deleted hands the registration of a resource deleted from the cluster to the deletion handler of the import
+func (d *DeletionHandlers) Deleted(key string, resource string, registration opslevel_jq_parser.ServiceRegistration) {
+	if handler := d.handler(key); handler != nil {
+		handler.Deleted(time.Now(), resource, registration)
+	}
+}
+
+// Authorized is true if held back deletions can be confirmed with the token
+func (d *DeletionHandlers) Authorized(token string) bool {
+	return d != nil && d.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+// Confirm applies the batch of deletions held back by the handler of the named import, a batch which changed since it
+// was reported is not applied.  It returns false if no handler holds back the batch.
+func (d *DeletionHandlers) Confirm(name string, batch string) bool {
+	d.mutex.Lock()
+	handlers := maps.Values(d.handlers)
+	d.mutex.Unlock()
+	confirmed := false
+	for _, handler := range handlers {
+		if handler.name == name && handler.Confirm(time.Now(), batch) {
+			confirmed = true
+		}
+	}
+	return confirmed
+}
+
+// DeletionHandler applies the onDelete policy of an import to the services of its kubernetes resources which were
+// deleted.  Deletions which would exceed the threshold are held back as a batch until the batch is confirmed or until
+// few enough of them are left, every deletion joining the batch makes it a new batch which has to be confirmed again.
+type DeletionHandler struct {
+	mutex      sync.Mutex
+	name       string
+	onDelete   OnDelete
+	reconciler *ServiceReconciler
+	index      *RegistrationIndex
+	guard      *deletionGuard
+	pending    map[string]opslevel_jq_parser.ServiceRegistration // by namespace/name of the resource
+	held       string                                            // the batch of pending deletions held back
+	confirmed  string                                            // the batch of pending deletions an operator confirmed
+}
+
+// NewDeletionHandler returns the handler of the import named like 'apps/v1/Deployment', the index holds the
+// registrations of the remaining resources of every import
+func NewDeletionHandler(name string, onDelete OnDelete, reconciler *ServiceReconciler, index *RegistrationIndex) *DeletionHandler {
+	return &DeletionHandler{
+		name:       name,
+		onDelete:   onDelete,
+		reconciler: reconciler,
+		index:      index,
+		guard:      newDeletionGuard(onDelete.Threshold, time.Hour),
+		pending:    map[string]opslevel_jq_parser.ServiceRegistration{},
+	}
+}
+
+// Deleted applies the policy to the service of the deleted resource unless the threshold holds it back
+func (h *DeletionHandler) Deleted(now time.Time, resource string, registration opslevel_jq_parser.ServiceRegistration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.update(func() {
+		h.pending[resource] = registration
+	})
+	h.apply(now)
+}
+
+// Sweep applies the held back deletions again, e.g. once the threshold allows them or their resources were recreated
+func (h *DeletionHandler) Sweep(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.apply(now)
+}
+
+// Confirm applies the held back deletions if they still are the batch, it returns false otherwise
+func (h *DeletionHandler) Confirm(now time.Time, batch string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if batch == "" || h.held != batch {
+		return false
+	}
+	log.Info().Msgf("[%s] Batch '%s' of deleted kubernetes resources was confirmed", h.name, batch)
+	h.confirmed = batch
+	h.apply(now)
+	return true
+}
+
+// Held returns the batch of deletions held back until it is confirmed, it is empty if no deletions are held back
+func (h *DeletionHandler) Held() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.held
+}
+
+// update changes the pending deletions and keeps the metric of the pending deletions up to date
+func (h *DeletionHandler) update(change func()) {
+	before := len(h.pending)
+	change()
+	pendingDeletions.Add(float64(len(h.pending) - before))
+}
+
+func (h *DeletionHandler) apply(now time.Time) {
+	h.update(func() {
+		for resource, registration := range h.pending {
+			// the resource was recreated or another resource still maps the service
+			if len(h.index.Related(registration.Aliases)) > 0 {
+				log.Info().Msgf("[%s] Aliases of deleted kubernetes resource '%s' are still produced by other resources ... skipping '%s' policy", h.name, resource, h.onDelete.Policy)
+				delete(h.pending, resource)
+			}
+		}
+	})
+	if len(h.pending) == 0 {
+		h.held, h.confirmed = "", ""
+		return
+	}
+	resources := maps.Keys(h.pending)
+	sort.Strings(resources)
+	batch := deletionBatch(resources)
+	if batch == h.confirmed {
+		log.Info().Msgf("[%s] Applying '%s' policy to %d confirmed deleted kubernetes resources", h.name, h.onDelete.Policy, len(resources))
+		h.guard.Record(now, len(resources))
+	} else if !h.guard.Allow(now, len(resources)) {
+		if h.held != batch {
+			h.held = batch
+			log.Error().Msgf("[%s] %d kubernetes resources were deleted which exceeds the onDelete threshold of %d per hour ... holding back '%s' policy until batch '%s' is confirmed\n\tRESOURCES: %s", h.name, len(resources), h.onDelete.Threshold, h.onDelete.Policy, batch, strings.Join(resources, ", "))
+		}
+		return
+	}
+	h.update(func() {
+		for _, resource := range resources {
+			if err := h.reconciler.HandleDeletion(h.pending[resource], h.onDelete.Policy); err != nil {
+				log.Error().Err(err).Msg("failed when handling deleted service")
+			}
+			delete(h.pending, resource)
+		}
+	})
+	h.held, h.confirmed = "", ""
+}
+
+// stop forgets the held back deletions since the handler no longer runs
+func (h *DeletionHandler) stop() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.update(func() {
+		h.pending = map[string]opslevel_jq_parser.ServiceRegistration{}
+	})
+}
+
+// deletionBatch identifies the sorted resources of a batch of held back deletions
+func deletionBatch(resources []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(resources, "\n")))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// deletionImportName names an import in logs and confirmations
+func deletionImportName(config Import) string {
+	return fmt.Sprintf("%s/%s", config.SelectorConfig.ApiVersion, config.SelectorConfig.Kind)
+}
+
+// SetupDeletionHandlers starts a deletion handler for every import which has an onDelete policy
+func SetupDeletionHandlers(ctx context.Context, config *Config, handlers *DeletionHandlers, reconciler *ServiceReconciler, interval time.Duration) *ImportRunner {
+	runner := NewDeletionRunner(ctx, handlers, reconciler, interval)
+	runner.Update(config.Service.Import)
+	return runner
+}
+
+// NewDeletionRunner returns a runner which starts a deletion handler for every import with an onDelete policy.  The
+// handlers apply the policy to the resources the controllers of their import see deleted and retry held back deletions
+// every interval.
+func NewDeletionRunner(ctx context.Context, handlers *DeletionHandlers, reconciler *ServiceReconciler, interval time.Duration) *ImportRunner {
+	return NewImportRunner(ctx, func(ctx context.Context, key string, config Import) error {
+		if err := config.OnDelete.Validate(); err != nil {
+			log.Error().Err(err).Msg("failed to setup deletion handler")
+			return err
+		}
+		if config.OnDelete.Policy == DeletePolicyIgnore {
+			return nil
+		}
+		handler := NewDeletionHandler(deletionImportName(config), config.OnDelete, reconciler, handlers.index)
+		handlers.register(key, handler)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					handlers.unregister(key, handler)
+					handler.stop()
+					return
+				case <-ticker.C:
+					handler.Sweep(time.Now())
+				}
+			}
+		}()
+		return nil
+	})
+}
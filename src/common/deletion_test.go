@@ -0,0 +1,197 @@
+package common_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func Test_Reconciler_HandleDeletion(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		policy          common.DeletePolicy
+		expectedAliases []string
+		expectedTags    map[string]string
+		expectedDeleted bool
+	}
+	testService := opslevel.Service{
+		ServiceId: opslevel.ServiceId{
+			Id:      opslevel.ID("XXX"),
+			Aliases: []string{"test", "k8s:test-default"},
+		},
+		Name: "test",
+	}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Name:    "test",
+		Aliases: []string{"k8s:test-default", "not_on_service"},
+	}
+	cases := map[string]TestCase{
+		"Ignore Does Nothing": {
+			policy: common.DeletePolicyIgnore,
+		},
+		"Detach Removes Existing Aliases": {
+			policy:          common.DeletePolicyDetach,
+			expectedAliases: []string{"k8s:test-default"},
+		},
+		"Tag Assigns Deleted Tag": {
+			policy:       common.DeletePolicyTag,
+			expectedTags: map[string]string{common.DeletedTagKey: "true"},
+		},
+		"Delete Deletes Service": {
+			policy:          common.DeletePolicyDelete,
+			expectedDeleted: true,
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		var (
+			deletedAliases []string
+			assignedTags   map[string]string
+			deleted        bool
+		)
+		reconciler := common.NewServiceReconciler(&common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return &testService, nil
+			},
+			DeleteAliasHandler: func(alias string) error {
+				deletedAliases = append(deletedAliases, alias)
+				return nil
+			},
+			AssignTagsHandler: func(service *opslevel.Service, tags map[string]string) error {
+				assignedTags = tags
+				return nil
+			},
+			DeleteServiceHandler: func(id opslevel.ID) error {
+				autopilot.Equals(t, testService.Id, id)
+				deleted = true
+				return nil
+			},
+		}, false, false)
+		err := reconciler.HandleDeletion(registration, test.policy)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedAliases, deletedAliases)
+		autopilot.Equals(t, test.expectedTags, assignedTags)
+		autopilot.Equals(t, test.expectedDeleted, deleted)
+	})
+}
+
+func Test_Reconciler_HandleDeletionNoServiceFound(t *testing.T) {
+	// Arrange
+	reconciler := common.NewServiceReconciler(&common.OpslevelClient{
+		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+			return &opslevel.Service{}, nil
+		},
+		DeleteServiceHandler: func(id opslevel.ID) error {
+			panic("should not be called")
+		},
+	}, false, false)
+	// Act
+	err := reconciler.HandleDeletion(opslevel_jq_parser.ServiceRegistration{Name: "test", Aliases: []string{"test"}}, common.DeletePolicyDelete)
+	// Assert
+	autopilot.Ok(t, err)
+}
+
+func TestOnDeleteValidate(t *testing.T) {
+	autopilot.Ok(t, common.OnDelete{Policy: common.DeletePolicyDetach}.Validate())
+	autopilot.Assert(t, common.OnDelete{Policy: "archive"}.Validate() != nil, "expected unknown policy to be invalid")
+}
+
+func indexResource(index *common.RegistrationIndex, resource string, aliases ...string) opslevel_jq_parser.ServiceRegistration {
+	registration := opslevel_jq_parser.ServiceRegistration{Name: resource, Aliases: aliases}
+	index.Set(common.SourcedRegistration{ServiceRegistration: registration, Source: "0:" + resource})
+	return registration
+}
+
+func newDetachingReconciler(detached *[]string) *common.ServiceReconciler {
+	return common.NewServiceReconciler(&common.OpslevelClient{
+		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+			return &opslevel.Service{
+				ServiceId: opslevel.ServiceId{Id: "XXX", Aliases: []string{"web", "k8s:web", "k8s:canary", "k8s:worker", "k8s:cron", "k8s:api"}},
+				Name:      "web",
+			}, nil
+		},
+		DeleteAliasHandler: func(alias string) error {
+			*detached = append(*detached, alias)
+			return nil
+		},
+	}, false, false)
+}
+
+func TestDeletionHandlerSkipsResourcesSharingAnAliasWithIndexedOnes(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		canary           []string
+		expectedDetached []string
+	}
+	cases := map[string]TestCase{
+		"Shared Alias Keeps Service Mapped": {
+			canary:           []string{"web", "k8s:canary"},
+			expectedDetached: nil,
+		},
+		"Own Aliases Are Detached": {
+			canary:           []string{"k8s:canary"},
+			expectedDetached: []string{"k8s:canary"},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		var detached []string
+		index := common.NewRegistrationIndex()
+		indexResource(index, "default/web", "web", "k8s:web")
+		handler := common.NewDeletionHandler("apps/v1/Deployment", common.OnDelete{Policy: common.DeletePolicyDetach, Threshold: 10}, newDetachingReconciler(&detached), index)
+		handler.Deleted(time.Now(), "default/canary", opslevel_jq_parser.ServiceRegistration{Name: "canary", Aliases: test.canary})
+		// Assert
+		autopilot.Equals(t, test.expectedDetached, detached)
+	})
+}
+
+func TestDeletionHandlerHoldsBackDeletionsAboveThreshold(t *testing.T) {
+	// Arrange
+	var detached []string
+	index := common.NewRegistrationIndex()
+	handler := common.NewDeletionHandler("apps/v1/Deployment", common.OnDelete{Policy: common.DeletePolicyDetach, Threshold: 1}, newDetachingReconciler(&detached), index)
+	now := time.Now()
+	registration := func(resource string, alias string) opslevel_jq_parser.ServiceRegistration {
+		return opslevel_jq_parser.ServiceRegistration{Name: resource, Aliases: []string{alias}}
+	}
+
+	// Act
+	handler.Deleted(now, "default/web", registration("web", "k8s:web"))
+	handler.Deleted(now, "default/worker", registration("worker", "k8s:worker"))
+	first := handler.Held()
+	handler.Deleted(now, "default/cron", registration("cron", "k8s:cron"))
+	second := handler.Held()
+	staleConfirmed := handler.Confirm(now, first)
+	held := append([]string{}, detached...)
+	indexResource(index, "default/worker", "k8s:worker")
+	handler.Sweep(now.Add(2 * time.Hour))
+	belowThreshold := append([]string{}, detached...)
+	handler.Deleted(now.Add(2*time.Hour), "default/api", registration("api", "k8s:api"))
+	confirmed := handler.Confirm(now.Add(2*time.Hour), handler.Held())
+
+	// Assert
+	autopilot.Assert(t, first != "" && first != second, "expected every deletion joining the batch to make it a new batch")
+	autopilot.Equals(t, false, staleConfirmed)
+	autopilot.Equals(t, []string{"k8s:web"}, held)
+	autopilot.Equals(t, []string{"k8s:web", "k8s:cron"}, belowThreshold)
+	autopilot.Equals(t, true, confirmed)
+	autopilot.Equals(t, []string{"k8s:web", "k8s:cron", "k8s:api"}, detached)
+	autopilot.Equals(t, "", handler.Held())
+}
+
+func TestDeletionHandlersRequireToken(t *testing.T) {
+	// Arrange
+	handlers := common.NewDeletionHandlers(common.NewRegistrationIndex(), "secret")
+
+	// Assert
+	autopilot.Equals(t, true, handlers.Authorized("secret"))
+	autopilot.Equals(t, false, handlers.Authorized("other"))
+	autopilot.Equals(t, false, handlers.Authorized(""))
+	autopilot.Equals(t, false, common.NewDeletionHandlers(nil, "").Authorized(""))
+	autopilot.Equals(t, false, handlers.Confirm("apps/v1/Deployment", "unknown"))
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+)
+
+// ExcludeResult is the output of a single selector exclude for a resource
+type ExcludeResult struct {
+	Expression string `json:"expression"`
+	Value      string `json:"value"`
+	Matched    bool   `json:"matched"`
+	Error      string `json:"error,omitempty"`
+}
+
+// FieldTrace is the output of a single jq expression of an import's opslevel mapping
+type FieldTrace struct {
+	Field      string `json:"field"`
+	Expression string `json:"expression"`
+	Value      string `json:"value"`
+	Error      string `json:"error,omitempty"`
+}
+
+// EvaluateResult is the outcome of an import whose selector apiVersion and kind match a resource
+type EvaluateResult struct {
+	Resource     string                                  `json:"resource"`
+	Import       int                                     `json:"import"`
+	Selected     bool                                    `json:"selected"` // namespaces and labels match
+	Excluded     bool                                    `json:"excluded"`
+	Excludes     []ExcludeResult                         `json:"excludes,omitempty"`
+	Registration *opslevel_jq_parser.ServiceRegistration `json:"registration,omitempty"`
+	Trace        []FieldTrace                            `json:"trace,omitempty"`
+	Error        string                                  `json:"error,omitempty"`
+}
+
+// Skipped is true if the resource would not be imported by the import
+func (r EvaluateResult) Skipped() bool {
+	return !r.Selected || r.Excluded
+}
+
+// ResourceName returns the kind, namespace and name of a kubernetes resource for display
+func ResourceName(resource map[string]any) string {
+	metadata, _ := resource["metadata"].(map[string]any)
+	name := fmt.Sprint(metadata["name"])
+	if namespace, ok := metadata["namespace"].(string); ok && namespace != "" {
+		name = namespace + "/" + name
+	}
+	return fmt.Sprintf("%s %s", resource["kind"], name)
+}
+
+// EvaluateConfig runs every import of the config whose selector apiVersion and kind match the resource the same way
+// the parser handler does.  With trace the output of every jq expression of the mapping is returned as well.
+func EvaluateConfig(config *Config, resource map[string]any, trace bool) []EvaluateResult {
+	var results []EvaluateResult
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return []EvaluateResult{{Resource: ResourceName(resource), Import: -1, Error: err.Error()}}
+	}
+	for i, importConfig := range config.Service.Import {
+		selector := importConfig.SelectorConfig
+		if resource["apiVersion"] != selector.ApiVersion || resource["kind"] != selector.Kind {
+			continue
+		}
+		result := EvaluateResult{Resource: ResourceName(resource), Import: i}
+		selector.Excludes = nil
+		result.Selected = MatchesSelector(selector, resource)
+		for _, expression := range importConfig.SelectorConfig.Excludes {
+			exclude := ExcludeResult{Expression: expression}
+			exclude.Value, err = evaluateExpression(expression, string(data))
+			if err != nil {
+				exclude.Error = err.Error()
+			} else if matched, _ := strconv.ParseBool(exclude.Value); matched {
+				exclude.Matched = true
+				result.Excluded = true
+			}
+			result.Excludes = append(result.Excludes, exclude)
+		}
+		result.Registration, err = parseRegistration(importConfig.OpslevelConfig, string(data))
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if trace {
+			result.Trace = traceRegistration(importConfig.OpslevelConfig, string(data))
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func parseRegistration(config opslevel_jq_parser.ServiceRegistrationConfig, data string) (registration *opslevel_jq_parser.ServiceRegistration, err error) {
+	// the jq parser panics on expressions which do not compile
+	defer func() {
+		if r := recover(); r != nil {
+			registration, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return opslevel_jq_parser.NewJQServiceParser(config).Run(data)
+}
+
+func evaluateExpression(expression, data string) (value string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, err = "", fmt.Errorf("%v", r)
+		}
+	}()
+	return opslevel_jq_parser.NewJQFieldParser(expression).Run(data)
+}
+
+// traceRegistration runs every expression of the mapping on its own in the order of the config file fields
+func traceRegistration(config opslevel_jq_parser.ServiceRegistrationConfig, data string) []FieldTrace {
+	var traces []FieldTrace
+	add := func(field, expression string) {
+		if expression == "" {
+			return
+		}
+		trace := FieldTrace{Field: field, Expression: expression}
+		value, err := evaluateExpression(expression, data)
+		if err != nil {
+			trace.Error = err.Error()
+		}
+		trace.Value = value
+		traces = append(traces, trace)
+	}
+	addAll := func(field string, expressions []string) {
+		for i, expression := range expressions {
+			add(fmt.Sprintf("%s[%d]", field, i), expression)
+		}
+	}
+	addAll("aliases", config.Aliases)
+	add("description", config.Description)
+	add("framework", config.Framework)
+	add("language", config.Language)
+	add("lifecycle", config.Lifecycle)
+	add("name", config.Name)
+	add("owner", config.Owner)
+	add("product", config.Product)
+	properties := make([]string, 0, len(config.Properties))
+	for key := range config.Properties {
+		properties = append(properties, key)
+	}
+	sort.Strings(properties)
+	for _, key := range properties {
+		add(fmt.Sprintf("properties.%s", key), config.Properties[key])
+	}
+	addAll("repositories", config.Repositories)
+	add("system", config.System)
+	addAll("tags.assign", config.Tags.Assign)
+	addAll("tags.create", config.Tags.Create)
+	add("tier", config.Tier)
+	addAll("tools", config.Tools)
+	return traces
+}
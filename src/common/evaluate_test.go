@@ -0,0 +1,66 @@
+package common_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+const evaluateConfig = `
+version: "1.3.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        excludes:
+          - .metadata.namespace == "kube-system"
+          - .metadata.labels.ignore
+      opslevel:
+        name: .metadata.name
+        owner: .metadata.annotations.owner
+        aliases:
+          - '"k8s:\(.metadata.name)-\(.metadata.namespace)"'
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        namespaces:
+          - jobs
+      opslevel:
+        name: .metadata.name
+`
+
+func TestEvaluateConfig(t *testing.T) {
+	// Arrange
+	config, err := common.ParseConfig(evaluateConfig)
+	autopilot.Ok(t, err)
+	resources, err := common.ReadManifests(strings.NewReader(testManifests))
+	autopilot.Ok(t, err)
+
+	// Act
+	web := common.EvaluateConfig(config, resources[0], true)
+	ignored := common.EvaluateConfig(config, resources[3], false)
+	service := common.EvaluateConfig(config, resources[2], false)
+
+	// Assert
+	autopilot.Equals(t, 2, len(web))
+	autopilot.Equals(t, "Deployment default/web", web[0].Resource)
+	autopilot.Equals(t, false, web[0].Skipped())
+	autopilot.Equals(t, []common.ExcludeResult{
+		{Expression: `.metadata.namespace == "kube-system"`, Value: "false"},
+		{Expression: ".metadata.labels.ignore", Value: ""},
+	}, web[0].Excludes)
+	autopilot.Equals(t, "web", web[0].Registration.Name)
+	autopilot.Equals(t, []string{"k8s:web-default"}, web[0].Registration.Aliases)
+	autopilot.Equals(t, []common.FieldTrace{
+		{Field: "aliases[0]", Expression: `"k8s:\(.metadata.name)-\(.metadata.namespace)"`, Value: "k8s:web-default"},
+		{Field: "name", Expression: ".metadata.name", Value: "web"},
+		{Field: "owner", Expression: ".metadata.annotations.owner", Value: ""},
+	}, web[0].Trace)
+	autopilot.Equals(t, false, web[1].Selected)
+	autopilot.Equals(t, true, ignored[0].Excluded)
+	autopilot.Equals(t, true, ignored[0].Excludes[0].Matched)
+	autopilot.Equals(t, 0, len(service))
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+)
+
+type EmptyValuePolicy string
+
+const (
+	EmptyValuePolicyIgnore        EmptyValuePolicy = "ignore"        // an empty value leaves the field untouched in OpsLevel
+	EmptyValuePolicyAuthoritative EmptyValuePolicy = "authoritative" // an empty value unsets the field in OpsLevel
+)
+
+// clearableFields are the registration fields that can be unset through the service update
+var clearableFields = []string{"description", "framework", "language", "lifecycle", "product", "tier"}
+
+func (p EmptyValuePolicy) Validate() error {
+	switch p {
+	case EmptyValuePolicyIgnore, EmptyValuePolicyAuthoritative:
+		return nil
+	default:
+		return fmt.Errorf("unknown empty value policy '%s' - must be one of [ignore, authoritative]", p)
+	}
+}
+
+// EmptyValuesConfig chooses what an empty or null value of a registration field does to the field of the service.
+// Fields are the registration fields like 'description' or 'tier', only those in clearableFields can be authoritative.
+type EmptyValuesConfig struct {
+	Policy EmptyValuePolicy            `yaml:"policy" json:"policy" default:"ignore" jsonschema:"enum=ignore,enum=authoritative"`
+	Fields map[string]EmptyValuePolicy `yaml:"fields" json:"fields"`
+}
+
+// Validate checks the policies against the conflicts config since a field skipped because of a conflict is empty as well
+func (c EmptyValuesConfig) Validate(conflicts ConflictsConfig) error {
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(c.Fields))
+	for field, policy := range c.Fields {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if c.Fields[field] == EmptyValuePolicyAuthoritative && !isClearableField(field) {
+			return fmt.Errorf("empty value policy 'authoritative' is not supported for '%s' - must be one of [%s]", field, strings.Join(clearableFields, ", "))
+		}
+	}
+	for _, field := range clearableFields {
+		if c.PolicyFor(field) == EmptyValuePolicyAuthoritative && conflicts.PolicyFor(field) == ConflictPolicySkip {
+			return fmt.Errorf("empty value policy 'authoritative' for '%s' cannot be combined with the conflict policy 'skip'", field)
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns the policy of the field, fields which cannot be unset are always ignored
+func (c EmptyValuesConfig) PolicyFor(field string) EmptyValuePolicy {
+	if !isClearableField(field) {
+		return EmptyValuePolicyIgnore
+	}
+	if policy, ok := c.Fields[field]; ok {
+		return policy
+	}
+	if c.Policy == "" {
+		return EmptyValuePolicyIgnore
+	}
+	return c.Policy
+}
+
+// Clears is true if an empty registration value unsets the field
+func (c EmptyValuesConfig) Clears(field string) bool {
+	return c.PolicyFor(field) == EmptyValuePolicyAuthoritative
+}
+
+func isClearableField(field string) bool {
+	for _, clearable := range clearableFields {
+		if field == clearable {
+			return true
+		}
+	}
+	return false
+}
+
+// WithEmptyValues sets which fields are unset when the registration has an empty value for them
+func (r *ServiceReconciler) WithEmptyValues(emptyValues EmptyValuesConfig) *ServiceReconciler {
+	r.emptyValues = emptyValues
+	return r
+}
+
+type ManagePolicy string
+
+const (
+	ManagePolicyAlways     ManagePolicy = "always"     // the field is set on create and overwritten on every update
+	ManagePolicyCreateOnly ManagePolicy = "createOnly" // the field is only set when the service is created
+	ManagePolicyNever      ManagePolicy = "never"      // the field is never touched
+)
+
+// manageStrictness orders the policies from the one touching a field most to the one touching it least
+var manageStrictness = map[ManagePolicy]int{ManagePolicyAlways: 0, ManagePolicyCreateOnly: 1, ManagePolicyNever: 2}
+
+func (p ManagePolicy) Validate() error {
+	if _, ok := manageStrictness[p]; !ok {
+		return fmt.Errorf("unknown manage policy '%s' - must be one of [always, createOnly, never]", p)
+	}
+	return nil
+}
+
+// ManageConfig chooses which fields of a service the registrations of an import own.  Fields edited in the OpsLevel UI
+// can be set only when the service is created or never be touched.  Fields are the registration fields like 'description'.
+type ManageConfig struct {
+	Policy ManagePolicy            `yaml:"policy" json:"policy" default:"always" jsonschema:"enum=always,enum=createOnly,enum=never"`
+	Fields map[string]ManagePolicy `yaml:"fields" json:"fields"`
+}
+
+func (c ManageConfig) Validate() error {
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(c.Fields))
+	for field := range c.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if scalarField(&opslevel_jq_parser.ServiceRegistration{}, field) == nil {
+			return fmt.Errorf("unknown manage field '%s' - must be one of [%s]", field, strings.Join(scalarFields, ", "))
+		}
+		if err := c.Fields[field].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns the policy of the field
+func (c ManageConfig) PolicyFor(field string) ManagePolicy {
+	if policy, ok := c.Fields[field]; ok {
+		return policy
+	}
+	return c.defaultPolicy()
+}
+
+func (c ManageConfig) defaultPolicy() ManagePolicy {
+	if c.Policy == "" {
+		return ManagePolicyAlways
+	}
+	return c.Policy
+}
+
+// OnCreate is true if the field is set when the service is created
+func (c ManageConfig) OnCreate(field string) bool {
+	return c.PolicyFor(field) != ManagePolicyNever
+}
+
+// OnUpdate is true if the field is updated on an existing service
+func (c ManageConfig) OnUpdate(field string) bool {
+	return c.PolicyFor(field) == ManagePolicyAlways
+}
+
+// restrict returns the config which touches every field only as much as both configs allow
+func (c ManageConfig) restrict(other ManageConfig) ManageConfig {
+	stricter := func(a, b ManagePolicy) ManagePolicy {
+		if manageStrictness[b] > manageStrictness[a] {
+			return b
+		}
+		return a
+	}
+	restricted := ManageConfig{
+		Policy: stricter(c.defaultPolicy(), other.defaultPolicy()),
+		Fields: map[string]ManagePolicy{},
+	}
+	for _, field := range scalarFields {
+		if policy := stricter(c.PolicyFor(field), other.PolicyFor(field)); policy != restricted.Policy {
+			restricted.Fields[field] = policy
+		}
+	}
+	return restricted
+}
@@ -0,0 +1,85 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestEmptyValuesConfigValidate(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config    common.EmptyValuesConfig
+		conflicts common.ConflictsConfig
+		valid     bool
+	}
+	cases := map[string]TestCase{
+		"Default":                     {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyIgnore}, valid: true},
+		"Unknown Policy":              {config: common.EmptyValuesConfig{Policy: "clear"}, valid: false},
+		"Unknown Field Policy":        {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyIgnore, Fields: map[string]common.EmptyValuePolicy{"product": "clear"}}, valid: false},
+		"Authoritative Field":         {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyIgnore, Fields: map[string]common.EmptyValuePolicy{"product": common.EmptyValuePolicyAuthoritative}}, valid: true},
+		"Authoritative Owner":         {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyIgnore, Fields: map[string]common.EmptyValuePolicy{"owner": common.EmptyValuePolicyAuthoritative}}, valid: false},
+		"Authoritative Policy":        {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyAuthoritative}, valid: true},
+		"Authoritative Skipped Field": {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyAuthoritative}, conflicts: common.ConflictsConfig{Fields: map[string]common.ConflictPolicy{"tier": common.ConflictPolicySkip}}, valid: false},
+		"Ignored Skipped Field":       {config: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyIgnore, Fields: map[string]common.EmptyValuePolicy{"product": common.EmptyValuePolicyAuthoritative}}, conflicts: common.ConflictsConfig{Policy: common.ConflictPolicySkip}, valid: false},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		err := test.config.Validate(test.conflicts)
+		// Assert
+		autopilot.Equals(t, test.valid, err == nil)
+	})
+}
+
+func TestEmptyValuesConfigPolicyFor(t *testing.T) {
+	// Arrange
+	config := common.EmptyValuesConfig{
+		Policy: common.EmptyValuePolicyAuthoritative,
+		Fields: map[string]common.EmptyValuePolicy{"tier": common.EmptyValuePolicyIgnore},
+	}
+	// Act
+	// Assert
+	autopilot.Equals(t, common.EmptyValuePolicyAuthoritative, config.PolicyFor("description"))
+	autopilot.Equals(t, common.EmptyValuePolicyIgnore, config.PolicyFor("tier"))
+	autopilot.Equals(t, common.EmptyValuePolicyIgnore, config.PolicyFor("owner"))
+	autopilot.Equals(t, common.EmptyValuePolicyIgnore, common.EmptyValuesConfig{}.PolicyFor("description"))
+}
+
+func TestManageConfigValidate(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config common.ManageConfig
+		valid  bool
+	}
+	cases := map[string]TestCase{
+		"Default":              {config: common.ManageConfig{Policy: common.ManagePolicyAlways}, valid: true},
+		"Unknown Policy":       {config: common.ManageConfig{Policy: "sometimes"}, valid: false},
+		"Field Policies":       {config: common.ManageConfig{Policy: common.ManagePolicyNever, Fields: map[string]common.ManagePolicy{"owner": common.ManagePolicyAlways, "description": common.ManagePolicyCreateOnly}}, valid: true},
+		"Unknown Field Policy": {config: common.ManageConfig{Policy: common.ManagePolicyAlways, Fields: map[string]common.ManagePolicy{"owner": "sometimes"}}, valid: false},
+		"Unknown Field":        {config: common.ManageConfig{Policy: common.ManagePolicyAlways, Fields: map[string]common.ManagePolicy{"tags": common.ManagePolicyNever}}, valid: false},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		err := test.config.Validate()
+		// Assert
+		autopilot.Equals(t, test.valid, err == nil)
+	})
+}
+
+func TestManageConfigPolicies(t *testing.T) {
+	// Arrange
+	config := common.ManageConfig{
+		Policy: common.ManagePolicyNever,
+		Fields: map[string]common.ManagePolicy{"owner": common.ManagePolicyAlways, "description": common.ManagePolicyCreateOnly},
+	}
+	// Act
+	// Assert
+	autopilot.Equals(t, true, config.OnCreate("owner"))
+	autopilot.Equals(t, true, config.OnUpdate("owner"))
+	autopilot.Equals(t, true, config.OnCreate("description"))
+	autopilot.Equals(t, false, config.OnUpdate("description"))
+	autopilot.Equals(t, false, config.OnCreate("tier"))
+	autopilot.Equals(t, false, config.OnUpdate("tier"))
+	autopilot.Equals(t, common.ManagePolicyAlways, common.ManageConfig{}.PolicyFor("tier"))
+}
@@ -0,0 +1,213 @@
+package common
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/maps"
+
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rs/zerolog/log"
+)
+
+// RegistrationIndex holds the latest registration of every kubernetes resource selected by the running controllers by
+// its source, so a registration can be merged with the registrations of the same service whose resources did not change.
+// A nil index holds nothing.
+type RegistrationIndex struct {
+	mutex   sync.Mutex
+	next    int
+	entries map[string]indexEntry
+	aliases map[string]map[string]bool // sources by alias
+	standby bool
+}
+
+type indexEntry struct {
+	order        int // registrations are related in the order their resources were first seen
+	registration SourcedRegistration
+}
+
+func NewRegistrationIndex() *RegistrationIndex {
+	return &RegistrationIndex{
+		entries: map[string]indexEntry{},
+		aliases: map[string]map[string]bool{},
+	}
+}
+
+// registrationSource identifies the resource a registration was parsed from, the prefix tells controllers apart
+func registrationSource(prefix, namespace, name string) string {
+	return prefix + namespace + "/" + name
+}
+
+// Set records the registration under its source replacing the previous registration of the source
+func (i *RegistrationIndex) Set(registration SourcedRegistration) {
+	i.record(registration)
+}
+
+// record is Set which reports if the registration should be sent into the queue, it should not during a standby
+func (i *RegistrationIndex) record(registration SourcedRegistration) bool {
+	if i == nil {
+		return true
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if registration.Source != "" {
+		i.set(registration)
+	}
+	return !i.standby
+}
+
+func (i *RegistrationIndex) set(registration SourcedRegistration) {
+	order := i.next
+	if previous, ok := i.entries[registration.Source]; ok {
+		order = previous.order
+		i.unlink(previous.registration)
+	} else {
+		i.next++
+	}
+	i.entries[registration.Source] = indexEntry{order: order, registration: registration}
+	for _, alias := range registration.Aliases {
+		if i.aliases[alias] == nil {
+			i.aliases[alias] = map[string]bool{}
+		}
+		i.aliases[alias][registration.Source] = true
+	}
+}
+
+// Standby makes the indexed parser handlers only record the registrations without sending them into the queue until
+// Lead, like on a replica which waits for the leader election and does not drain the queue
+func (i *RegistrationIndex) Standby() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.standby = true
+}
+
+// Lead ends a standby and sends every registration recorded until then into the queue in the order their resources
+// were first seen.  It returns once every registration was sent or the context is done.
+func (i *RegistrationIndex) Lead(ctx context.Context, producers *QueueProducers) {
+	if i == nil || !producers.Add() {
+		return
+	}
+	defer producers.Done()
+	i.mutex.Lock()
+	if !i.standby {
+		i.mutex.Unlock()
+		return
+	}
+	i.standby = false
+	entries := maps.Values(i.entries)
+	i.mutex.Unlock()
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].order < entries[b].order
+	})
+	log.Info().Msgf("Replaying %d registrations recorded during the standby", len(entries))
+	for _, entry := range entries {
+		queueDepth.Inc()
+		select {
+		case <-ctx.Done():
+			queueDepth.Dec()
+			return
+		case producers.queue <- entry.registration:
+		}
+	}
+}
+
+// Delete forgets the registration of the source and returns it
+func (i *RegistrationIndex) Delete(source string) (SourcedRegistration, bool) {
+	if i == nil {
+		return SourcedRegistration{}, false
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	entry, ok := i.entries[source]
+	if ok {
+		i.unlink(entry.registration)
+		delete(i.entries, source)
+	}
+	return entry.registration, ok
+}
+
+// DeletePrefix forgets the registrations of every source starting with the prefix, like those of a stopped controller
+func (i *RegistrationIndex) DeletePrefix(prefix string) {
+	if i == nil {
+		return
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	for source, entry := range i.entries {
+		if strings.HasPrefix(source, prefix) {
+			i.unlink(entry.registration)
+			delete(i.entries, source)
+		}
+	}
+}
+
+func (i *RegistrationIndex) unlink(registration SourcedRegistration) {
+	for _, alias := range registration.Aliases {
+		delete(i.aliases[alias], registration.Source)
+		if len(i.aliases[alias]) == 0 {
+			delete(i.aliases, alias)
+		}
+	}
+}
+
+// Related returns every registration connected to any of the aliases through shared aliases in the order their
+// resources were first seen
+func (i *RegistrationIndex) Related(aliases []string) []SourcedRegistration {
+	if i == nil {
+		return nil
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	var (
+		visited = map[string]bool{}
+		sources = map[string]bool{}
+		pending = append([]string{}, aliases...)
+	)
+	for len(pending) > 0 {
+		alias := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[alias] {
+			continue
+		}
+		visited[alias] = true
+		for source := range i.aliases[alias] {
+			if sources[source] {
+				continue
+			}
+			sources[source] = true
+			pending = append(pending, i.entries[source].registration.Aliases...)
+		}
+	}
+	entries := make([]indexEntry, 0, len(sources))
+	for source := range sources {
+		entries = append(entries, i.entries[source])
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].order < entries[b].order
+	})
+	related := make([]SourcedRegistration, len(entries))
+	for n, entry := range entries {
+		related[n] = entry.registration
+	}
+	return related
+}
+
+// WithRegistrationIndex makes the reconciler prune only the values which none of the indexed registrations mapped to the
+// service produces, a registration is mapped to the service if it shares an alias with the service
+func (r *ServiceReconciler) WithRegistrationIndex(index *RegistrationIndex) *ServiceReconciler {
+	r.index = index
+	return r
+}
+
+// mappedRegistrations returns the registration followed by every indexed registration mapped to the service
+func (r *ServiceReconciler) mappedRegistrations(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) []opslevel_jq_parser.ServiceRegistration {
+	aliases := append(append(append([]string{}, registration.Aliases...), service.Aliases...), service.ManagedAliases...)
+	mapped := []opslevel_jq_parser.ServiceRegistration{registration}
+	for _, related := range r.index.Related(aliases) {
+		mapped = append(mapped, related.ServiceRegistration)
+	}
+	return mapped
+}
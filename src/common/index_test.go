@@ -0,0 +1,44 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func relatedSources(index *common.RegistrationIndex, aliases ...string) []string {
+	sources := []string{}
+	for _, registration := range index.Related(aliases) {
+		sources = append(sources, registration.Source)
+	}
+	return sources
+}
+
+func TestRegistrationIndexRelatesRegistrationsThroughAliases(t *testing.T) {
+	// Arrange
+	index := common.NewRegistrationIndex()
+	set := func(source string, aliases ...string) {
+		index.Set(common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Aliases: aliases}, Source: source})
+	}
+	set("1:default/b", "b")
+	set("1:default/a", "a")
+	set("2:default/ab", "a", "b")
+	set("2:default/c", "c")
+
+	// Act
+	related := relatedSources(index, "a")
+	set("2:default/ab", "a")
+	relinked := relatedSources(index, "a")
+	index.Delete("1:default/a")
+	deleted := relatedSources(index, "a")
+	index.DeletePrefix("2:")
+
+	// Assert
+	autopilot.Equals(t, []string{"1:default/b", "1:default/a", "2:default/ab"}, related)
+	autopilot.Equals(t, []string{"1:default/a", "2:default/ab"}, relinked)
+	autopilot.Equals(t, []string{"2:default/ab"}, deleted)
+	autopilot.Equals(t, []string{}, relatedSources(index, "a", "c"))
+	autopilot.Equals(t, []string{"1:default/b"}, relatedSources(index, "b"))
+}
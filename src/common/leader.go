@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	opslevel_k8s_controller "github.com/opslevel/opslevel-k8s-controller/v2024"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LeaderElection configures the Lease used to elect a single leader between replicas of `service reconcile`
+type LeaderElection struct {
+	Namespace string
+	Name      string
+	Identity  string
+}
+
+// DefaultLeaderElectionNamespace returns the namespace the pod runs in falling back to 'default' outside a cluster
+func DefaultLeaderElectionNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return "default"
+}
+
+// DefaultLeaderElectionIdentity returns the hostname which is the pod name inside a cluster
+func DefaultLeaderElectionIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "kubectl-opslevel"
+	}
+	return hostname
+}
+
+// RunLeaderElection blocks campaigning for the lease and calls lead once this replica becomes the leader.  It returns
+// once the context is done and lead returned, so nothing is written to OpsLevel anymore.  Leadership is never handed
+// back while running, if the lease is lost the process exits so it restarts as a standby.
+func RunLeaderElection(ctx context.Context, election LeaderElection, lead func(ctx context.Context)) error {
+	k8sClient, err := opslevel_k8s_controller.NewK8SClient()
+	if err != nil {
+		return err
+	}
+	return RunLeaderElectionWithClient(ctx, election, k8sClient.Client.CoordinationV1(), lead)
+}
+
+// RunLeaderElectionWithClient is RunLeaderElection which reads and writes the lease through the client
+func RunLeaderElectionWithClient(ctx context.Context, election LeaderElection, client coordinationv1.CoordinationV1Interface, lead func(ctx context.Context)) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      election.Name,
+			Namespace: election.Namespace,
+		},
+		Client:     client,
+		LockConfig: resourcelock.ResourceLockConfig{Identity: election.Identity},
+	}
+	var (
+		mutex   sync.Mutex
+		stopped bool
+		leading sync.WaitGroup
+	)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		Name:            election.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				// the elector calls this in a goroutine which can start after it returned
+				mutex.Lock()
+				if stopped {
+					mutex.Unlock()
+					return
+				}
+				leading.Add(1)
+				mutex.Unlock()
+				defer leading.Done()
+				log.Info().Msgf("[%s] Became the leader of lease '%s/%s'", election.Identity, election.Namespace, election.Name)
+				isLeader.Set(1)
+				lead(ctx)
+			},
+			OnStoppedLeading: func() {
+				isLeader.Set(0)
+				if ctx.Err() != nil {
+					log.Info().Msgf("[%s] Released lease '%s/%s'", election.Identity, election.Namespace, election.Name)
+					return
+				}
+				log.Fatal().Msgf("[%s] Lost lease '%s/%s' ... exiting", election.Identity, election.Namespace, election.Name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != election.Identity {
+					log.Info().Msgf("[%s] Waiting as a standby, current leader is '%s'", election.Identity, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("[%s] Campaigning for lease '%s/%s'", election.Identity, election.Namespace, election.Name)
+	elector.Run(ctx)
+	mutex.Lock()
+	stopped = true
+	mutex.Unlock()
+	leading.Wait()
+	return nil
+}
@@ -0,0 +1,43 @@
+package common_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDefaultLeaderElectionNamespace(t *testing.T) {
+	// Arrange
+	t.Setenv("POD_NAMESPACE", "opslevel")
+
+	// Assert
+	autopilot.Equals(t, "opslevel", common.DefaultLeaderElectionNamespace())
+	autopilot.Assert(t, common.DefaultLeaderElectionIdentity() != "", "expected a leader election identity")
+}
+
+func TestRunLeaderElectionWaitsForLead(t *testing.T) {
+	// Arrange
+	election := common.LeaderElection{Namespace: "default", Name: "kubectl-opslevel", Identity: "replica-0"}
+	client := fake.NewSimpleClientset().CoordinationV1()
+	ctx, cancel := context.WithCancel(context.Background())
+	var finished atomic.Bool
+	lead := func(ctx context.Context) {
+		cancel()
+		<-ctx.Done()
+		// still writing to OpsLevel after the context is done
+		time.Sleep(100 * time.Millisecond)
+		finished.Store(true)
+	}
+
+	// Act
+	err := common.RunLeaderElectionWithClient(ctx, election, client, lead)
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, true, finished.Load())
+}
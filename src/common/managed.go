@@ -0,0 +1,341 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rs/zerolog/log"
+)
+
+// ManagedTags declares the tag keys kubectl-opslevel owns.  Managed tags on a service that no longer
+// appear in any of its registrations are deleted, all other tags are never touched.
+type ManagedTags struct {
+	Keys     []string `yaml:"keys" json:"keys" mapstructure:"keys"`
+	Prefixes []string `yaml:"prefixes" json:"prefixes" mapstructure:"prefixes"`
+}
+
+// Enabled is true if any keys or prefixes are managed
+func (m ManagedTags) Enabled() bool {
+	return len(m.Keys) > 0 || len(m.Prefixes) > 0
+}
+
+// Owns is true if the tag key is managed by kubectl-opslevel
+func (m ManagedTags) Owns(key string) bool {
+	for _, managed := range m.Keys {
+		if strings.EqualFold(key, managed) {
+			return true
+		}
+	}
+	for _, prefix := range m.Prefixes {
+		if strings.HasPrefix(strings.ToLower(key), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+type TagsConfig struct {
+	Managed ManagedTags `yaml:"managed" json:"managed" mapstructure:"managed"`
+}
+
+// WithManagedTags enables pruning of the managed tags that are no longer part of a registration
+func (r *ServiceReconciler) WithManagedTags(managed ManagedTags) *ServiceReconciler {
+	r.managedTags = managed
+	return r
+}
+
+func (r *ServiceReconciler) handlePruneTags(service *opslevel.Service, registrations []opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
+	if !r.managedTags.Enabled() || service.Tags == nil {
+		return
+	}
+	desired := map[string]bool{}
+	for _, registration := range registrations {
+		for _, tag := range append(registration.TagAssigns, registration.TagCreates...) {
+			desired[fmt.Sprintf("%s = %s", strings.ToLower(tag.Key), tag.Value)] = true
+		}
+	}
+	for _, tag := range service.Tags.Nodes {
+		if !r.managedTags.Owns(tag.Key) {
+			continue
+		}
+		target := fmt.Sprintf("%s = %s", strings.ToLower(tag.Key), tag.Value)
+		if desired[target] {
+			continue
+		}
+		err := r.client.DeleteTag(tag.Id)
+		result.Record(ChangeActionDeleteTag, fmt.Sprintf("%s = %s", tag.Key, tag.Value), err)
+		if err != nil {
+			log.Error().Msgf("[%s] Failed deleting managed tag '%s = %s'\n\tREASON: %v", service.Name, tag.Key, tag.Value, err.Error())
+		} else {
+			log.Info().Msgf("[%s] Deleted managed tag '%s = %s'", service.Name, tag.Key, tag.Value)
+		}
+	}
+}
+
+// ManagedTools declares the tool categories kubectl-opslevel owns.  Tools in a managed category on a service
+// that no longer appear in any of its registrations are deleted, tools in other categories are never touched.
+type ManagedTools struct {
+	Categories []opslevel.ToolCategory `yaml:"categories" json:"categories" mapstructure:"categories"`
+}
+
+// Enabled is true if any tool categories are managed
+func (m ManagedTools) Enabled() bool {
+	return len(m.Categories) > 0
+}
+
+// Owns is true if tools of the category are managed by kubectl-opslevel
+func (m ManagedTools) Owns(category opslevel.ToolCategory) bool {
+	for _, managed := range m.Categories {
+		if category == managed {
+			return true
+		}
+	}
+	return false
+}
+
+type ToolsConfig struct {
+	Managed ManagedTools `yaml:"managed" json:"managed" mapstructure:"managed"`
+}
+
+// WithManagedTools enables pruning of the tools in managed categories that are no longer part of a registration
+func (r *ServiceReconciler) WithManagedTools(managed ManagedTools) *ServiceReconciler {
+	r.managedTools = managed
+	return r
+}
+
+func (r *ServiceReconciler) handlePruneTools(service *opslevel.Service, registrations []opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
+	if !r.managedTools.Enabled() || service.Tools == nil {
+		return
+	}
+	desired := map[string]bool{}
+	for _, registration := range registrations {
+		for _, tool := range registration.Tools {
+			toolEnv := ""
+			if tool.Environment != nil {
+				toolEnv = *tool.Environment
+			}
+			desired[fmt.Sprintf("%s/%s/%s", tool.Category, toolEnv, tool.DisplayName)] = true
+		}
+	}
+	for _, tool := range service.Tools.Nodes {
+		if !r.managedTools.Owns(tool.Category) || desired[fmt.Sprintf("%s/%s/%s", tool.Category, tool.Environment, tool.DisplayName)] {
+			continue
+		}
+		err := r.client.DeleteTool(tool.Id)
+		result.Record(ChangeActionDeleteTool, tool.DisplayName, err)
+		if err != nil {
+			log.Error().Msgf("[%s] Failed deleting managed tool '{Category: %s, Environment: %s, Name: %s}'\n\tREASON: %v", service.Name, tool.Category, tool.Environment, tool.DisplayName, err.Error())
+		} else {
+			log.Info().Msgf("[%s] Deleted managed tool '{Category: %s, Environment: %s, Name: %s}'", service.Name, tool.Category, tool.Environment, tool.DisplayName)
+		}
+	}
+}
+
+// ManagedAliases declares the aliases kubectl-opslevel owns by prefix or by a regular expression matching the whole
+// alias.  Managed aliases on a service that no longer appear in any of its registrations are deleted, all other aliases are
+// never touched.
+type ManagedAliases struct {
+	Prefixes []string `yaml:"prefixes" json:"prefixes" mapstructure:"prefixes"`
+	Patterns []string `yaml:"patterns" json:"patterns" mapstructure:"patterns"`
+}
+
+// Enabled is true if any prefixes or patterns are managed
+func (m ManagedAliases) Enabled() bool {
+	return len(m.Prefixes) > 0 || len(m.Patterns) > 0
+}
+
+func (m ManagedAliases) Validate() error {
+	for _, pattern := range m.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid managed alias pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Owns is true if the alias is managed by kubectl-opslevel
+func (m ManagedAliases) Owns(alias string) bool {
+	for _, prefix := range m.Prefixes {
+		if strings.HasPrefix(alias, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range m.Patterns {
+		if compiled := aliasPattern(pattern); compiled != nil && compiled.MatchString(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasPatterns holds the managed alias patterns compiled to match the whole alias, a pattern which does not compile is
+// held as nil and never matches
+var aliasPatterns sync.Map
+
+func aliasPattern(pattern string) *regexp.Regexp {
+	if compiled, ok := aliasPatterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp)
+	}
+	compiled, _ := regexp.Compile("^(?:" + pattern + ")$")
+	aliasPatterns.Store(pattern, compiled)
+	return compiled
+}
+
+type AliasesConfig struct {
+	Managed ManagedAliases `yaml:"managed" json:"managed" mapstructure:"managed"`
+}
+
+// WithManagedAliases enables pruning of the managed aliases that are no longer part of a registration
+func (r *ServiceReconciler) WithManagedAliases(managed ManagedAliases) *ServiceReconciler {
+	r.managedAliases = managed
+	return r
+}
+
+// handlePruneAliases deletes managed aliases which none of the registrations of the service produces.  Only aliases set
+// through the API can be deleted, the identifiers OpsLevel creates for a service are never touched.
+func (r *ServiceReconciler) handlePruneAliases(service *opslevel.Service, registrations []opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
+	if !r.managedAliases.Enabled() {
+		return
+	}
+	desired := map[string]bool{}
+	for _, registration := range registrations {
+		for _, alias := range registration.Aliases {
+			desired[alias] = true
+		}
+	}
+	for _, alias := range service.ManagedAliases {
+		if desired[alias] || !r.managedAliases.Owns(alias) {
+			continue
+		}
+		err := r.client.DeleteAlias(alias)
+		result.Record(ChangeActionDeleteAlias, alias, err)
+		if err != nil {
+			log.Error().Msgf("[%s] Failed deleting managed alias '%s'\n\tREASON: %v", service.Name, alias, err.Error())
+		} else {
+			log.Info().Msgf("[%s] Deleted managed alias '%s'", service.Name, alias)
+		}
+	}
+}
+
+// ManagedRepositories declares the repositories kubectl-opslevel owns the service links of by a prefix of their alias,
+// e.g. 'github.com:my-org/'.  OpsLevel does not record who linked a repository to a service, so links of managed
+// repositories on a service that no longer appear in any of its registrations are deleted, all other links are never touched.
+type ManagedRepositories struct {
+	Prefixes []string `yaml:"prefixes" json:"prefixes" mapstructure:"prefixes"`
+}
+
+// Enabled is true if any repository prefixes are managed
+func (m ManagedRepositories) Enabled() bool {
+	return len(m.Prefixes) > 0
+}
+
+// Owns is true if the links of the repository are managed by kubectl-opslevel
+func (m ManagedRepositories) Owns(alias string) bool {
+	for _, prefix := range m.Prefixes {
+		if strings.HasPrefix(strings.ToLower(alias), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RepositoryMatch decides which existing service repository link a repository of a registration updates
+type RepositoryMatch string
+
+const (
+	RepositoryMatchBaseDirectory RepositoryMatch = "baseDirectory" // the link of the repository with the same base directory
+	RepositoryMatchRepository    RepositoryMatch = "repository"    // the only link of the repository, its base directory is updated in place
+)
+
+func (m RepositoryMatch) Validate() error {
+	switch m {
+	case RepositoryMatchBaseDirectory, RepositoryMatchRepository:
+		return nil
+	default:
+		return fmt.Errorf("unknown repository match '%s' - must be one of [baseDirectory, repository]", m)
+	}
+}
+
+type RepositoriesConfig struct {
+	Managed ManagedRepositories `yaml:"managed" json:"managed" mapstructure:"managed"`
+	Match   RepositoryMatch     `yaml:"match" json:"match" mapstructure:"match" default:"baseDirectory" jsonschema:"enum=baseDirectory,enum=repository"`
+}
+
+// WithRepositoryMatch sets how repositories of a registration are matched to the existing links of the service
+func (r *ServiceReconciler) WithRepositoryMatch(match RepositoryMatch) *ServiceReconciler {
+	r.repositoryMatch = match
+	return r
+}
+
+// WithManagedRepositories enables deleting the links of managed repositories that are no longer part of a registration
+func (r *ServiceReconciler) WithManagedRepositories(managed ManagedRepositories) *ServiceReconciler {
+	r.managedRepositories = managed
+	return r
+}
+
+func serviceRepositoryKey(repository opslevel.ID, baseDirectory string) string {
+	return fmt.Sprintf("%s:%s", repository, strings.Trim(baseDirectory, "/"))
+}
+
+// handleMappedRepositories adds the links of managed repositories the other registrations of the service want to linked.
+// complete is false if any of their repositories could not be looked up.
+func (r *ServiceReconciler) handleMappedRepositories(service *opslevel.Service, registrations []opslevel_jq_parser.ServiceRegistration, linked map[string]bool) (complete bool) {
+	if !r.managedRepositories.Enabled() {
+		return true
+	}
+	found := map[string]*opslevel.Repository{}
+	for _, registration := range registrations {
+		for _, inputRepository := range registration.Repositories {
+			alias := inputRepository.Repository.Alias
+			if alias == nil || *alias == "null" || *alias == "" || !r.managedRepositories.Owns(*alias) {
+				continue
+			}
+			repository, ok := found[*alias]
+			if !ok {
+				var err error
+				if repository, err = r.client.GetRepositoryWithAlias(*alias); err != nil {
+					log.Error().Err(err).Str("service", service.Name).Str("repo", *alias).Msgf("fetching repository of another registration of the service in OpsLevel resulted in an error ... skipping pruning")
+					return false
+				}
+				found[*alias] = repository
+			}
+			if repository == nil {
+				continue
+			}
+			baseDirectory := ""
+			if inputRepository.BaseDirectory != nil {
+				baseDirectory = *inputRepository.BaseDirectory
+			}
+			linked[serviceRepositoryKey(repository.Id, baseDirectory)] = true
+		}
+	}
+	return true
+}
+
+func (r *ServiceReconciler) handlePruneRepositories(service *opslevel.Service, linked map[string]bool, result *ReconcileResult) {
+	if !r.managedRepositories.Enabled() || service.Repositories == nil {
+		return
+	}
+	for _, edge := range service.Repositories.Edges {
+		if !r.managedRepositories.Owns(edge.Node.DefaultAlias) {
+			continue
+		}
+		for _, serviceRepository := range edge.ServiceRepositories {
+			if linked[serviceRepositoryKey(edge.Node.Id, serviceRepository.BaseDirectory)] {
+				continue
+			}
+			target := fmt.Sprintf("%s:/%s", edge.Node.DefaultAlias, strings.Trim(serviceRepository.BaseDirectory, "/"))
+			err := r.client.DeleteServiceRepository(serviceRepository.Id)
+			result.Record(ChangeActionDeleteServiceRepository, target, err)
+			if err != nil {
+				log.Error().Msgf("[%s] Failed detaching managed repository '%s'\n\tREASON: %v", service.Name, target, err.Error())
+			} else {
+				log.Info().Msgf("[%s] Detached managed repository '%s'", service.Name, target)
+			}
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package common_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestManagedTagsOwns(t *testing.T) {
+	// Arrange
+	managed := common.ManagedTags{Keys: []string{"env"}, Prefixes: []string{"k8s-"}}
+
+	// Assert
+	autopilot.Equals(t, true, managed.Enabled())
+	autopilot.Equals(t, true, managed.Owns("env"))
+	autopilot.Equals(t, true, managed.Owns("ENV"))
+	autopilot.Equals(t, true, managed.Owns("k8s-team"))
+	autopilot.Equals(t, false, managed.Owns("environment"))
+	autopilot.Equals(t, false, managed.Owns("team"))
+	autopilot.Equals(t, false, common.ManagedTags{}.Enabled())
+}
+
+func TestReconcilerPrunesManagedTags(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		managed         common.ManagedTags
+		expectedDeletes []opslevel.ID
+	}
+	service := opslevel.Service{
+		ServiceId: opslevel.ServiceId{Id: opslevel.ID("XXX"), Aliases: []string{"test"}},
+		Name:      "test",
+		Tags: &opslevel.TagConnection{Nodes: []opslevel.Tag{
+			{Id: "tag-env", Key: "env", Value: "staging"},
+			{Id: "tag-label", Key: "k8s-app", Value: "test"},
+			{Id: "tag-stale", Key: "k8s-version", Value: "1"},
+			{Id: "tag-manual", Key: "team", Value: "platform"},
+		}},
+		Tools: &opslevel.ToolConnection{},
+	}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases:    []string{"test"},
+		TagAssigns: []opslevel.TagInput{{Key: "k8s-app", Value: "test"}},
+		TagCreates: []opslevel.TagInput{{Key: "env", Value: "prod"}},
+	}
+	cases := map[string]TestCase{
+		"Disabled": {
+			managed:         common.ManagedTags{},
+			expectedDeletes: []opslevel.ID{},
+		},
+		"Keys And Prefixes": {
+			managed:         common.ManagedTags{Keys: []string{"env"}, Prefixes: []string{"k8s-"}},
+			expectedDeletes: []opslevel.ID{"tag-env", "tag-stale"},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		deletes := []opslevel.ID{}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return &service, nil
+			},
+			DeleteTagHandler: func(id opslevel.ID) error {
+				deletes = append(deletes, id)
+				return nil
+			},
+		}
+		result, err := common.NewServiceReconciler(client, false, false).WithManagedTags(test.managed).Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedDeletes, deletes)
+		autopilot.Equals(t, false, result.Failed())
+	})
+}
+
+func TestManagedAliasesOwns(t *testing.T) {
+	// Arrange
+	managed := common.ManagedAliases{Prefixes: []string{"k8s:"}, Patterns: []string{`[a-z]+-[a-z]+`}}
+
+	// Assert
+	autopilot.Equals(t, true, managed.Enabled())
+	autopilot.Equals(t, true, managed.Owns("k8s:web-default"))
+	autopilot.Equals(t, true, managed.Owns("default-web"))
+	autopilot.Equals(t, false, managed.Owns("web"))
+	autopilot.Equals(t, false, managed.Owns("default-web-1"))
+	autopilot.Equals(t, true, managed.Owns("default-web"))
+	autopilot.Equals(t, false, common.ManagedAliases{Patterns: []string{"k8s:("}}.Owns("k8s:("))
+	autopilot.Equals(t, false, common.ManagedAliases{}.Enabled())
+	autopilot.Ok(t, managed.Validate())
+	autopilot.Equals(t, "invalid managed alias pattern 'k8s:(': error parsing regexp: missing closing ): `k8s:(`",
+		common.ManagedAliases{Patterns: []string{"k8s:("}}.Validate().Error())
+}
+
+func TestReconcilerPrunesManagedAliases(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		managed         common.ManagedAliases
+		expectedDeletes []string
+	}
+	service := opslevel.Service{
+		ServiceId:      opslevel.ServiceId{Id: opslevel.ID("XXX"), Aliases: []string{"web", "web_service", "k8s:web-default", "k8s:web-staging", "manual"}},
+		ManagedAliases: []string{"web", "k8s:web-default", "k8s:web-staging", "manual"},
+		Name:           "web",
+		Tags:           &opslevel.TagConnection{},
+		Tools:          &opslevel.ToolConnection{},
+	}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases: []string{"web", "k8s:web-default"},
+	}
+	cases := map[string]TestCase{
+		"Disabled": {
+			managed:         common.ManagedAliases{},
+			expectedDeletes: []string{},
+		},
+		"Prefix": {
+			managed:         common.ManagedAliases{Prefixes: []string{"k8s:"}},
+			expectedDeletes: []string{"k8s:web-staging"},
+		},
+		"Pattern Never Deletes OpsLevel Identifiers": {
+			managed:         common.ManagedAliases{Patterns: []string{"web.*", "k8s:.*"}},
+			expectedDeletes: []string{"k8s:web-staging"},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		deletes := []string{}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return &service, nil
+			},
+			DeleteAliasHandler: func(alias string) error {
+				deletes = append(deletes, alias)
+				return nil
+			},
+		}
+		result, err := common.NewServiceReconciler(client, false, false).WithManagedAliases(test.managed).Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedDeletes, deletes)
+		autopilot.Equals(t, false, result.Failed())
+	})
+}
+
+func TestReconcilerUpdatesAndPrunesTools(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		managed         common.ManagedTools
+		expectedUpdates []opslevel.ToolUpdateInput
+		expectedDeletes []opslevel.ID
+	}
+	service := opslevel.Service{
+		ServiceId: opslevel.ServiceId{Id: opslevel.ID("XXX"), Aliases: []string{"test"}},
+		Name:      "test",
+		Tags:      &opslevel.TagConnection{},
+		Tools: &opslevel.ToolConnection{Nodes: []opslevel.Tool{
+			{Id: "tool-grafana", Category: opslevel.ToolCategoryMetrics, DisplayName: "Grafana", Url: "https://grafana/old"},
+			{Id: "tool-stale", Category: opslevel.ToolCategoryMetrics, DisplayName: "Datadog", Url: "https://datadog"},
+			{Id: "tool-runbook", Category: opslevel.ToolCategoryRunbooks, DisplayName: "Runbook", Url: "https://runbook"},
+		}},
+	}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases: []string{"test"},
+		Tools: []opslevel.ToolCreateInput{
+			{Category: opslevel.ToolCategoryMetrics, DisplayName: "Grafana", Url: "https://grafana/new"},
+		},
+	}
+	cases := map[string]TestCase{
+		"Update Only": {
+			managed:         common.ManagedTools{},
+			expectedUpdates: []opslevel.ToolUpdateInput{{Id: "tool-grafana", Url: opslevel.RefOf("https://grafana/new")}},
+			expectedDeletes: []opslevel.ID{},
+		},
+		"Update And Prune Managed Category": {
+			managed:         common.ManagedTools{Categories: []opslevel.ToolCategory{opslevel.ToolCategoryMetrics}},
+			expectedUpdates: []opslevel.ToolUpdateInput{{Id: "tool-grafana", Url: opslevel.RefOf("https://grafana/new")}},
+			expectedDeletes: []opslevel.ID{"tool-stale"},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		updates := []opslevel.ToolUpdateInput{}
+		deletes := []opslevel.ID{}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return &service, nil
+			},
+			CreateToolHandler: func(tool opslevel.ToolCreateInput) error {
+				panic("should not be called")
+			},
+			UpdateToolHandler: func(input opslevel.ToolUpdateInput) error {
+				updates = append(updates, input)
+				return nil
+			},
+			DeleteToolHandler: func(id opslevel.ID) error {
+				deletes = append(deletes, id)
+				return nil
+			},
+		}
+		_, err := common.NewServiceReconciler(client, false, false).WithManagedTools(test.managed).Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedUpdates, updates)
+		autopilot.Equals(t, test.expectedDeletes, deletes)
+	})
+}
+
+func newLinkedService() *opslevel.Service {
+	return &opslevel.Service{
+		ServiceId: opslevel.ServiceId{Id: opslevel.ID("XXX"), Aliases: []string{"web"}},
+		Name:      "web",
+		Tags:      &opslevel.TagConnection{},
+		Tools:     &opslevel.ToolConnection{},
+		Repositories: &opslevel.ServiceRepositoryConnection{Edges: []opslevel.ServiceRepositoryEdge{
+			{
+				Node: opslevel.RepositoryId{Id: "repo-web", DefaultAlias: "github.com:acme/web"},
+				ServiceRepositories: []opslevel.ServiceRepository{
+					{Id: "link-root", BaseDirectory: ""},
+					{Id: "link-stale", BaseDirectory: "/old"},
+				},
+			},
+			{
+				Node:                opslevel.RepositoryId{Id: "repo-removed", DefaultAlias: "github.com:acme/removed"},
+				ServiceRepositories: []opslevel.ServiceRepository{{Id: "link-removed", BaseDirectory: "/"}},
+			},
+			{
+				Node:                opslevel.RepositoryId{Id: "repo-manual", DefaultAlias: "gitlab.com:other/manual"},
+				ServiceRepositories: []opslevel.ServiceRepository{{Id: "link-manual", BaseDirectory: ""}},
+			},
+		}},
+	}
+}
+
+func TestReconcilerPrunesManagedRepositories(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		managed         common.ManagedRepositories
+		lookupErr       error
+		expectedDeletes []opslevel.ID
+	}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases: []string{"web"},
+		Repositories: []opslevel.ServiceRepositoryCreateInput{
+			{Repository: *opslevel.NewIdentifier("github.com:acme/web")},
+		},
+	}
+	cases := map[string]TestCase{
+		"Disabled": {
+			managed:         common.ManagedRepositories{},
+			expectedDeletes: []opslevel.ID{},
+		},
+		"Prefix": {
+			managed:         common.ManagedRepositories{Prefixes: []string{"github.com:acme/"}},
+			expectedDeletes: []opslevel.ID{"link-stale", "link-removed"},
+		},
+		"Lookup Error Skips Pruning": {
+			managed:         common.ManagedRepositories{Prefixes: []string{"github.com:acme/"}},
+			lookupErr:       fmt.Errorf("502"),
+			expectedDeletes: []opslevel.ID{},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		deletes := []opslevel.ID{}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return newLinkedService(), nil
+			},
+			GetRepositoryWithAliasHandler: func(alias string) (*opslevel.Repository, error) {
+				return &opslevel.Repository{Id: "repo-web", Services: &opslevel.RepositoryServiceConnection{Edges: []opslevel.RepositoryServiceEdge{{
+					Node:                opslevel.ServiceId{Id: "XXX"},
+					ServiceRepositories: []opslevel.ServiceRepository{{Id: "link-root", Service: opslevel.ServiceId{Id: "XXX"}}},
+				}}}}, test.lookupErr
+			},
+			DeleteServiceRepositoryHandler: func(id opslevel.ID) error {
+				deletes = append(deletes, id)
+				return nil
+			},
+		}
+		_, err := common.NewServiceReconciler(client, false, false).WithManagedRepositories(test.managed).Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedDeletes, deletes)
+	})
+}
+
+func TestPlanReportsDetachedRepositories(t *testing.T) {
+	// Arrange
+	client, plan := common.NewPlanOpslevelClient(&common.OpslevelClient{
+		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+			return newLinkedService(), nil
+		},
+		DeleteServiceRepositoryHandler: func(id opslevel.ID) error {
+			panic("should not be called")
+		},
+	})
+	reconciler := common.NewServiceReconciler(client, false, false).
+		WithManagedRepositories(common.ManagedRepositories{Prefixes: []string{"github.com:acme/removed"}})
+
+	// Act
+	_, err := reconciler.Reconcile(opslevel_jq_parser.ServiceRegistration{Aliases: []string{"web"}})
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, []common.PlannedChange{{
+		Service: "web",
+		Action:  common.ChangeActionDeleteServiceRepository,
+		Input:   common.PlannedServiceRepository{Id: "link-removed", Repository: "github.com:acme/removed", BaseDirectory: "/"},
+	}}, plan.Changes)
+}
+
+func TestReconcilerKeepsValuesOfMappedRegistrations(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		indexed         bool
+		lookupErr       error
+		expectedDeletes []string
+	}
+	service := newLinkedService()
+	service.Aliases = []string{"web", "k8s:web-worker", "k8s:web-stale"}
+	service.ManagedAliases = []string{"k8s:web-worker", "k8s:web-stale"}
+	service.Tags = &opslevel.TagConnection{Nodes: []opslevel.Tag{
+		{Id: "tag-web", Key: "k8s-app", Value: "web"},
+		{Id: "tag-worker", Key: "k8s-app", Value: "worker"},
+		{Id: "tag-stale", Key: "k8s-app", Value: "stale"},
+	}}
+	service.Tools = &opslevel.ToolConnection{Nodes: []opslevel.Tool{
+		{Id: "tool-worker", Category: opslevel.ToolCategoryMetrics, DisplayName: "Worker"},
+		{Id: "tool-stale", Category: opslevel.ToolCategoryMetrics, DisplayName: "Stale"},
+	}}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases:      []string{"web"},
+		TagAssigns:   []opslevel.TagInput{{Key: "k8s-app", Value: "web"}},
+		Repositories: []opslevel.ServiceRepositoryCreateInput{{Repository: *opslevel.NewIdentifier("github.com:acme/web")}},
+	}
+	// the worker is mapped to the service through its alias, but its registration does not share an alias with the web one
+	worker := common.SourcedRegistration{Source: "0:default/worker", ServiceRegistration: opslevel_jq_parser.ServiceRegistration{
+		Aliases:      []string{"k8s:web-worker"},
+		TagAssigns:   []opslevel.TagInput{{Key: "k8s-app", Value: "worker"}},
+		Tools:        []opslevel.ToolCreateInput{{Category: opslevel.ToolCategoryMetrics, DisplayName: "Worker"}},
+		Repositories: []opslevel.ServiceRepositoryCreateInput{{Repository: *opslevel.NewIdentifier("github.com:acme/removed"), BaseDirectory: opslevel.RefOf("/")}},
+	}}
+	cases := map[string]TestCase{
+		"Without Index": {
+			expectedDeletes: []string{"k8s:web-worker", "k8s:web-stale", "tag-worker", "tag-stale", "tool-worker", "tool-stale", "link-stale", "link-removed"},
+		},
+		"With Index": {
+			indexed:         true,
+			expectedDeletes: []string{"k8s:web-stale", "tag-stale", "tool-stale", "link-stale"},
+		},
+		"Lookup Error Of Mapped Registration Skips Repository Pruning": {
+			indexed:         true,
+			lookupErr:       fmt.Errorf("502"),
+			expectedDeletes: []string{"k8s:web-stale", "tag-stale", "tool-stale"},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		deletes := []string{}
+		remember := func(id string) error {
+			deletes = append(deletes, id)
+			return nil
+		}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return service, nil
+			},
+			GetRepositoryWithAliasHandler: func(alias string) (*opslevel.Repository, error) {
+				if alias == "github.com:acme/removed" {
+					return &opslevel.Repository{Id: "repo-removed"}, test.lookupErr
+				}
+				return &opslevel.Repository{Id: "repo-web", Services: &opslevel.RepositoryServiceConnection{Edges: []opslevel.RepositoryServiceEdge{{
+					Node:                opslevel.ServiceId{Id: "XXX"},
+					ServiceRepositories: []opslevel.ServiceRepository{{Id: "link-root", Service: opslevel.ServiceId{Id: "XXX"}}},
+				}}}}, nil
+			},
+			DeleteAliasHandler:             remember,
+			DeleteTagHandler:               func(id opslevel.ID) error { return remember(string(id)) },
+			DeleteToolHandler:              func(id opslevel.ID) error { return remember(string(id)) },
+			DeleteServiceRepositoryHandler: func(id opslevel.ID) error { return remember(string(id)) },
+		}
+		index := common.NewRegistrationIndex()
+		if test.indexed {
+			index.Set(worker)
+		}
+		_, err := common.NewServiceReconciler(client, false, false).
+			WithManagedAliases(common.ManagedAliases{Prefixes: []string{"k8s:"}}).
+			WithManagedTags(common.ManagedTags{Keys: []string{"k8s-app"}}).
+			WithManagedTools(common.ManagedTools{Categories: []opslevel.ToolCategory{opslevel.ToolCategoryMetrics}}).
+			WithManagedRepositories(common.ManagedRepositories{Prefixes: []string{"github.com:acme/"}}).
+			WithRegistrationIndex(index).
+			Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedDeletes, deletes)
+	})
+}
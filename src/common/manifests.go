@@ -0,0 +1,208 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	opslevel_k8s_controller "github.com/opslevel/opslevel-k8s-controller/v2024"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ReadManifests decodes every kubernetes resource from multi-document YAML or JSON, resources of a 'List' or
+// a top-level array are flattened
+func ReadManifests(reader io.Reader) ([]map[string]any, error) {
+	var resources []map[string]any
+	decoder := yaml.NewDecoder(reader)
+	for {
+		var document any
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch document := document.(type) {
+		case map[string]any:
+			resources = append(resources, flattenManifest(document)...)
+		case []any:
+			for _, item := range document {
+				if resource, ok := item.(map[string]any); ok {
+					resources = append(resources, flattenManifest(resource)...)
+				}
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("expected a kubernetes resource or a list of them but got '%T'", document)
+		}
+	}
+	return resources, nil
+}
+
+// clusterScopedKinds are the built-in kinds which have no namespace
+var clusterScopedKinds = map[schema.GroupKind]bool{
+	{Group: "", Kind: "Namespace"}:                                                    true,
+	{Group: "", Kind: "Node"}:                                                         true,
+	{Group: "", Kind: "PersistentVolume"}:                                             true,
+	{Group: "", Kind: "ComponentStatus"}:                                              true,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}:                         true,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}:                  true,
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:                 true,
+	{Group: "apiregistration.k8s.io", Kind: "APIService"}:                             true,
+	{Group: "storage.k8s.io", Kind: "StorageClass"}:                                   true,
+	{Group: "storage.k8s.io", Kind: "CSIDriver"}:                                      true,
+	{Group: "storage.k8s.io", Kind: "CSINode"}:                                        true,
+	{Group: "storage.k8s.io", Kind: "VolumeAttachment"}:                               true,
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}:     true,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}:   true,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingAdmissionPolicy"}:        true,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingAdmissionPolicyBinding"}: true,
+	{Group: "certificates.k8s.io", Kind: "CertificateSigningRequest"}:                 true,
+	{Group: "networking.k8s.io", Kind: "IngressClass"}:                                true,
+	{Group: "node.k8s.io", Kind: "RuntimeClass"}:                                      true,
+	{Group: "scheduling.k8s.io", Kind: "PriorityClass"}:                               true,
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "FlowSchema"}:                       true,
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "PriorityLevelConfiguration"}:       true,
+}
+
+// DefaultNamespace sets the namespace of every resource without one, like the output of 'helm template', the same
+// way the cluster would when they are applied.  Built-in cluster scoped kinds like Namespace or ClusterRole are skipped.
+func DefaultNamespace(resources []map[string]any, namespace string) {
+	for _, resource := range resources {
+		apiVersion, _ := resource["apiVersion"].(string)
+		kind, _ := resource["kind"].(string)
+		if groupVersion, err := schema.ParseGroupVersion(apiVersion); err == nil && clusterScopedKinds[groupVersion.WithKind(kind).GroupKind()] {
+			continue
+		}
+		metadata, ok := resource["metadata"].(map[string]any)
+		if !ok {
+			metadata = map[string]any{}
+			resource["metadata"] = metadata
+		}
+		if value, _ := metadata["namespace"].(string); value == "" {
+			metadata["namespace"] = namespace
+		}
+	}
+}
+
+func flattenManifest(document map[string]any) []map[string]any {
+	if document == nil {
+		return nil
+	}
+	kind, _ := document["kind"].(string)
+	items, ok := document["items"].([]any)
+	if !ok || !strings.HasSuffix(kind, "List") {
+		return []map[string]any{document}
+	}
+	var resources []map[string]any
+	for _, item := range items {
+		if resource, ok := item.(map[string]any); ok {
+			resources = append(resources, flattenManifest(resource)...)
+		}
+	}
+	return resources
+}
+
+func readManifestFile(path string) ([]map[string]any, error) {
+	if path == "-" {
+		return ReadManifests(os.Stdin)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	resources, err := ReadManifests(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifests from '%s': %w", path, err)
+	}
+	return resources, nil
+}
+
+// ReadManifestFiles reads the resources from every file ('-' reads stdin) and every .yaml, .yml or .json file found recursively
+// in the directories.  Resources without a namespace are put into the namespace.
+func ReadManifestFiles(files []string, dirs []string, namespace string) ([]map[string]any, error) {
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var resources []map[string]any
+	for _, file := range files {
+		found, err := readManifestFile(file)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, found...)
+	}
+	DefaultNamespace(resources, namespace)
+	return resources, nil
+}
+
+// MatchesSelector is true if the resource has the selector's apiVersion and kind, is in one of its namespaces,
+// matches its label selectors and does not match any of its excludes
+func MatchesSelector(selector opslevel_k8s_controller.K8SSelector, resource map[string]any) bool {
+	if resource["apiVersion"] != selector.ApiVersion || resource["kind"] != selector.Kind {
+		return false
+	}
+	filter := opslevel_k8s_controller.NewK8SFilter(selector)
+	if !filter.MatchesNamespace(resource) {
+		return false
+	}
+	requirements, err := labels.Parse(strings.Join(selector.Labels, ","))
+	if err != nil {
+		log.Warn().Err(err).Msgf("invalid label selector %q", selector.Labels)
+		return false
+	}
+	metadata, _ := resource["metadata"].(map[string]any)
+	resourceLabels, _ := metadata["labels"].(map[string]any)
+	set := labels.Set{}
+	for key, value := range resourceLabels {
+		set[key] = fmt.Sprint(value)
+	}
+	return requirements.Matches(set) && !filter.MatchesFilter(resource)
+}
+
+// SetupManifests runs a goroutine which parses the resources matching every import instead of reading them
+// from a kubernetes cluster and records their registrations in the index, it is a producer of the queue until every
+// resource was parsed
+func SetupManifests(ctx context.Context, config *Config, resources []map[string]any, producers *QueueProducers, index *RegistrationIndex) {
+	if !producers.Add() {
+		return
+	}
+	go func() {
+		defer producers.Done()
+		for i, importConfig := range config.Service.Import {
+			callback := NewIndexedParserHandler(ctx, importConfig, producers.queue, index, fmt.Sprintf("%d:", i))
+			matched := 0
+			for _, resource := range resources {
+				if MatchesSelector(importConfig.SelectorConfig, resource) {
+					callback(resource)
+					matched++
+				}
+			}
+			log.Info().Msgf("[%s/%s] Matched %d of %d manifests", importConfig.SelectorConfig.ApiVersion, importConfig.SelectorConfig.Kind, matched, len(resources))
+		}
+	}()
+}
@@ -0,0 +1,189 @@
+package common_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	opslevel_k8s_controller "github.com/opslevel/opslevel-k8s-controller/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+const testManifests = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+  labels:
+    app: web
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: worker
+      namespace: jobs
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: web
+      namespace: default
+---
+{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "ignored", "namespace": "kube-system"}}
+`
+
+func TestReadManifests(t *testing.T) {
+	// Act
+	resources, err := common.ReadManifests(strings.NewReader(testManifests))
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, 4, len(resources))
+	autopilot.Equals(t, "worker", resources[1]["metadata"].(map[string]any)["name"])
+	autopilot.Equals(t, "Service", resources[2]["kind"])
+}
+
+func TestReadManifestsArray(t *testing.T) {
+	// Act
+	resources, err := common.ReadManifests(strings.NewReader(`[{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web"}}, {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "worker"}}]`))
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, 2, len(resources))
+	autopilot.Equals(t, "worker", resources[1]["metadata"].(map[string]any)["name"])
+}
+
+func TestDefaultNamespace(t *testing.T) {
+	// Arrange
+	resources, err := common.ReadManifests(strings.NewReader("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n---\n" + testManifests))
+	autopilot.Ok(t, err)
+
+	// Act
+	common.DefaultNamespace(resources, "staging")
+
+	// Assert
+	autopilot.Equals(t, "staging", resources[0]["metadata"].(map[string]any)["namespace"])
+	autopilot.Equals(t, "default", resources[1]["metadata"].(map[string]any)["namespace"])
+	autopilot.Equals(t, true, common.MatchesSelector(opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Namespaces: []string{"staging"}}, resources[0]))
+}
+
+func TestDefaultNamespaceSkipsClusterScopedKinds(t *testing.T) {
+	// Arrange
+	resources, err := common.ReadManifests(strings.NewReader(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: production
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+apiVersion: example.com/v1
+kind: Namespace
+metadata:
+  name: widget
+`))
+	autopilot.Ok(t, err)
+
+	// Act
+	common.DefaultNamespace(resources, "staging")
+
+	// Assert
+	for _, resource := range resources[:3] {
+		_, ok := resource["metadata"].(map[string]any)["namespace"]
+		autopilot.Equals(t, false, ok)
+	}
+	autopilot.Equals(t, "staging", resources[3]["metadata"].(map[string]any)["namespace"])
+}
+
+func TestMatchesSelector(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		selector opslevel_k8s_controller.K8SSelector
+		expected []bool
+	}
+	resources, err := common.ReadManifests(strings.NewReader(testManifests))
+	autopilot.Ok(t, err)
+	cases := map[string]TestCase{
+		"Kind": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment"},
+			expected: []bool{true, true, false, true},
+		},
+		"Excludes": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Excludes: []string{`.metadata.namespace == "kube-system"`}},
+			expected: []bool{true, true, false, false},
+		},
+		"Namespaces": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Namespaces: []string{"jobs"}},
+			expected: []bool{false, true, false, false},
+		},
+		"Labels": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Labels: []string{"app=web"}},
+			expected: []bool{true, false, false, false},
+		},
+		"Labels Not Equal": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Labels: []string{"app!=web"}},
+			expected: []bool{false, true, false, true},
+		},
+		"Labels In": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Labels: []string{"app in (web,api)"}},
+			expected: []bool{true, false, false, false},
+		},
+		"Labels Exists": {
+			selector: opslevel_k8s_controller.K8SSelector{ApiVersion: "apps/v1", Kind: "Deployment", Labels: []string{"app"}},
+			expected: []bool{true, false, false, false},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		matches := make([]bool, len(resources))
+		for i, resource := range resources {
+			matches[i] = common.MatchesSelector(test.selector, resource)
+		}
+		// Assert
+		autopilot.Equals(t, test.expected, matches)
+	})
+}
+
+func TestSetupManifests(t *testing.T) {
+	// Arrange
+	config, err := common.ParseConfig(`
+version: "1.3.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        excludes:
+          - .metadata.namespace == "kube-system"
+      opslevel:
+        name: .metadata.name
+        aliases:
+          - '"k8s:\(.metadata.name)-\(.metadata.namespace)"'
+`)
+	autopilot.Ok(t, err)
+	resources, err := common.ReadManifests(strings.NewReader(testManifests))
+	autopilot.Ok(t, err)
+	queue := make(chan common.SourcedRegistration, 1)
+	producers := common.NewQueueProducers(queue)
+
+	// Act
+	common.SetupManifests(context.Background(), config, resources, producers, nil)
+	producers.Close()
+	services := *common.AggregateServices(common.NewCoalescer(config.Service.Conflicts).Run(queue, 0))
+
+	// Assert
+	autopilot.Equals(t, 2, len(services))
+	autopilot.Equals(t, "web", services[0].Name)
+	autopilot.Equals(t, []string{"k8s:worker-jobs"}, services[1].Aliases)
+}
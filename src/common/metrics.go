@@ -0,0 +1,219 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/opslevel/opslevel-go/v2024"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsNamespace = "kubectl_opslevel"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "Number of parsed service registrations waiting to be reconciled.",
+	})
+	reconcilesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconciles_total",
+		Help:      "Number of service reconciliations by outcome, a reconciliation with any failed operation counts as failed.",
+	}, []string{"outcome"})
+	apiCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_call_duration_seconds",
+		Help:      "Latency of OpsLevel API calls by client handler and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "status"})
+	pendingDeletions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_deletions",
+		Help:      "Number of deleted kubernetes resources whose onDelete policy is held back by the threshold until confirmed.",
+	})
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "1 while this replica holds the leader election lease.",
+	})
+
+	// lastCacheSync is the unix time in nanoseconds of the last opslevel-go cache sync
+	lastCacheSync    atomic.Int64
+	informersSynced  atomic.Bool
+	cacheSyncAgeFunc = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_sync_age_seconds",
+		Help:      "Seconds since the opslevel-go caches were last synced, NaN before the first sync.",
+	}, func() float64 {
+		last := lastCacheSync.Load()
+		if last == 0 {
+			return math.NaN()
+		}
+		return time.Since(time.Unix(0, last)).Seconds()
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		queueDepth,
+		reconcilesTotal,
+		apiCallDuration,
+		pendingDeletions,
+		isLeader,
+		cacheSyncAgeFunc,
+	)
+}
+
+func recordCacheSync() {
+	lastCacheSync.Store(time.Now().UnixNano())
+}
+
+func recordReconcile(result *ReconcileResult) {
+	if result == nil {
+		return
+	}
+	outcome := string(result.Action)
+	if result.Failed() {
+		outcome = string(ReconcileActionFailed)
+	}
+	reconcilesTotal.WithLabelValues(outcome).Inc()
+}
+
+func observeAPICall(handler string, start time.Time, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	apiCallDuration.WithLabelValues(handler, status).Observe(time.Since(start).Seconds())
+}
+
+// Ready is true once the opslevel-go caches and every kubernetes informer have synced
+func Ready() bool {
+	return lastCacheSync.Load() != 0 && informersSynced.Load()
+}
+
+// NewMetricsHandler returns the handler serving '/healthz', '/readyz', '/metrics' and '/deletions/confirm' if the
+// deletions can be confirmed with a token
+func NewMetricsHandler(deletions *DeletionHandlers) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("not ready"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	if deletions != nil && deletions.token != "" {
+		mux.HandleFunc("/deletions/confirm", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if !deletions.Authorized(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !deletions.Confirm(r.URL.Query().Get("import"), r.URL.Query().Get("batch")) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte("no deletions of the import are held back as the batch"))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("ok"))
+		})
+	}
+	return mux
+}
+
+// ServeMetrics runs a goroutine serving the health and metrics endpoints on the address until the context is cancelled
+func ServeMetrics(ctx context.Context, address string, deletions *DeletionHandlers) {
+	server := &http.Server{Addr: address, Handler: NewMetricsHandler(deletions), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+	go func() {
+		log.Info().Msgf("Serving health and metrics endpoints on '%s'", address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("failed to serve health and metrics endpoints")
+		}
+	}()
+}
+
+func instrument[I any](name string, handler func(I) error) func(I) error {
+	if handler == nil {
+		return nil
+	}
+	return func(input I) error {
+		start := time.Now()
+		err := handler(input)
+		observeAPICall(name, start, err)
+		return err
+	}
+}
+
+func instrumentWithResult[I, O any](name string, handler func(I) (O, error)) func(I) (O, error) {
+	if handler == nil {
+		return nil
+	}
+	return func(input I) (O, error) {
+		start := time.Now()
+		output, err := handler(input)
+		observeAPICall(name, start, err)
+		return output, err
+	}
+}
+
+// NewInstrumentedOpslevelClient returns a client which records the latency of every call made through the wrapped client
+func NewInstrumentedOpslevelClient(client *OpslevelClient) *OpslevelClient {
+	instrumented := &OpslevelClient{
+		GetServiceHandler:              instrumentWithResult("GetService", client.GetServiceHandler),
+		CreateServiceHandler:           instrumentWithResult("CreateService", client.CreateServiceHandler),
+		UpdateServiceHandler:           instrumentWithResult("UpdateService", client.UpdateServiceHandler),
+		DeleteServiceHandler:           instrument("DeleteService", client.DeleteServiceHandler),
+		CreateAliasHandler:             instrument("CreateAlias", client.CreateAliasHandler),
+		DeleteAliasHandler:             instrument("DeleteAlias", client.DeleteAliasHandler),
+		GetPropertiesHandler:           instrumentWithResult("GetProperties", client.GetPropertiesHandler),
+		AssignPropertyHandler:          instrument("AssignProperty", client.AssignPropertyHandler),
+		CreateTagHandler:               instrument("CreateTag", client.CreateTagHandler),
+		DeleteTagHandler:               instrument("DeleteTag", client.DeleteTagHandler),
+		CreateToolHandler:              instrument("CreateTool", client.CreateToolHandler),
+		UpdateToolHandler:              instrument("UpdateTool", client.UpdateToolHandler),
+		DeleteToolHandler:              instrument("DeleteTool", client.DeleteToolHandler),
+		GetRepositoryWithAliasHandler:  instrumentWithResult("GetRepositoryWithAlias", client.GetRepositoryWithAliasHandler),
+		CreateServiceRepositoryHandler: instrument("CreateServiceRepository", client.CreateServiceRepositoryHandler),
+		UpdateServiceRepositoryHandler: instrument("UpdateServiceRepository", client.UpdateServiceRepositoryHandler),
+		DeleteServiceRepositoryHandler: instrument("DeleteServiceRepository", client.DeleteServiceRepositoryHandler),
+	}
+	if client.AssignTagsHandler != nil {
+		instrumented.AssignTagsHandler = func(service *opslevel.Service, tags map[string]string) error {
+			start := time.Now()
+			err := client.AssignTagsHandler(service, tags)
+			observeAPICall("AssignTags", start, err)
+			return err
+		}
+	}
+	return instrumented
+}
@@ -0,0 +1,75 @@
+package common_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	return request(t, handler, http.MethodGet, path, "")
+}
+
+func request(t *testing.T, handler http.Handler, method string, path string, token string) (int, string) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	handler.ServeHTTP(recorder, req)
+	body, err := io.ReadAll(recorder.Result().Body)
+	autopilot.Ok(t, err)
+	return recorder.Code, string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	// Arrange
+	handler := common.NewMetricsHandler(nil)
+	client := common.NewInstrumentedOpslevelClient(&common.OpslevelClient{
+		CreateTagHandler: func(input opslevel.TagCreateInput) error {
+			return fmt.Errorf("api error")
+		},
+	})
+
+	// Act
+	autopilot.Assert(t, client.CreateTag(opslevel.TagCreateInput{}) != nil, "expected instrumented client to return the error")
+	healthz, _ := get(t, handler, "/healthz")
+	readyz, _ := get(t, handler, "/readyz")
+	confirm, _ := request(t, handler, http.MethodPost, "/deletions/confirm", "")
+	metrics, body := get(t, handler, "/metrics")
+
+	// Assert
+	autopilot.Equals(t, http.StatusOK, healthz)
+	autopilot.Equals(t, http.StatusServiceUnavailable, readyz)
+	autopilot.Equals(t, http.StatusNotFound, confirm)
+	autopilot.Equals(t, http.StatusOK, metrics)
+	autopilot.Assert(t, strings.Contains(body, `kubectl_opslevel_api_call_duration_seconds_count{handler="CreateTag",status="error"} 1`), "expected api call latency to be recorded")
+	autopilot.Assert(t, strings.Contains(body, "kubectl_opslevel_queue_depth"), "expected queue depth metric")
+	autopilot.Assert(t, strings.Contains(body, "kubectl_opslevel_cache_sync_age_seconds"), "expected cache sync age metric")
+	autopilot.Assert(t, strings.Contains(body, "kubectl_opslevel_pending_deletions"), "expected pending deletions metric")
+}
+
+func TestMetricsHandlerConfirmsDeletionsWithToken(t *testing.T) {
+	// Arrange
+	handler := common.NewMetricsHandler(common.NewDeletionHandlers(common.NewRegistrationIndex(), "secret"))
+	path := "/deletions/confirm?import=apps/v1/Deployment&batch=0123456789ab"
+
+	// Act
+	get, _ := request(t, handler, http.MethodGet, path, "secret")
+	anonymous, _ := request(t, handler, http.MethodPost, path, "")
+	wrongToken, _ := request(t, handler, http.MethodPost, path, "other")
+	unknownBatch, _ := request(t, handler, http.MethodPost, path, "secret")
+
+	// Assert
+	autopilot.Equals(t, http.StatusMethodNotAllowed, get)
+	autopilot.Equals(t, http.StatusUnauthorized, anonymous)
+	autopilot.Equals(t, http.StatusUnauthorized, wrongToken)
+	autopilot.Equals(t, http.StatusNotFound, unknownBatch)
+}
@@ -0,0 +1,203 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigMigration upgrades a configuration file from one version to the next.  Migrate edits the yaml document in
+// place so comments and ordering of the file are kept, a nil Migrate only bumps the version.
+type ConfigMigration struct {
+	From        string
+	To          string
+	Description string
+	Migrate     func(root *yaml.Node) error
+}
+
+// ConfigMigrations is the chain of migrations up to ConfigCurrentVersion, every version bump registers one here
+var ConfigMigrations = []ConfigMigration{
+	{
+		From:        "1.0.0",
+		To:          "1.1.0",
+		Description: "selectors require an apiVersion next to the kind, labels are a list of 'key=value' and excludes a list of jq expressions",
+		Migrate:     migrateSelectors,
+	},
+	{
+		From:        "1.1.0",
+		To:          "1.2.0",
+		Description: "only adds fields, existing configurations are unchanged",
+	},
+	{
+		From:        "1.2.0",
+		To:          "1.3.0",
+		Description: "adds properties to the opslevel mapping",
+	},
+}
+
+// MigrateConfig upgrades the configuration file to ConfigCurrentVersion and returns the migrations that were applied.
+// A file which already has the current version is returned unchanged.
+func MigrateConfig(data string) (string, []ConfigMigration, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &document); err != nil {
+		return "", nil, err
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return "", nil, fmt.Errorf("the config file is empty")
+	}
+	root := document.Content[0]
+	version := mappingValue(root, "version")
+	if version == nil || version.Value == "" {
+		return "", nil, fmt.Errorf("could not parse version in the config file")
+	}
+	if version.Value == ConfigCurrentVersion {
+		return data, nil, nil
+	}
+	var applied []ConfigMigration
+	for version.Value != ConfigCurrentVersion {
+		migration, ok := findConfigMigration(version.Value)
+		if !ok {
+			return "", nil, fmt.Errorf("supported config version is '%s' but found '%s' which cannot be migrated", ConfigCurrentVersion, version.Value)
+		}
+		if migration.Migrate != nil {
+			if err := migration.Migrate(root); err != nil {
+				return "", nil, fmt.Errorf("migrating config from '%s' to '%s': %w", migration.From, migration.To, err)
+			}
+		}
+		version.Value = migration.To
+		version.Style = yaml.DoubleQuotedStyle
+		applied = append(applied, migration)
+	}
+	var output bytes.Buffer
+	encoder := yaml.NewEncoder(&output)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return "", nil, err
+	}
+	return output.String(), applied, nil
+}
+
+func findConfigMigration(from string) (ConfigMigration, bool) {
+	for _, migration := range ConfigMigrations {
+		if migration.From == from {
+			return migration, true
+		}
+	}
+	return ConfigMigration{}, false
+}
+
+// mappingValue returns the value node of the key in a yaml mapping or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets the key of a yaml mapping, new keys are inserted before the key 'before' if it exists
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node, before string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	entry := []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == before {
+			node.Content = append(node.Content[:i], append(entry, node.Content[i:]...)...)
+			return
+		}
+	}
+	node.Content = append(node.Content, entry...)
+}
+
+// knownKinds maps the lowercased kinds 1.0.0 selectors used to their kind and apiVersion
+var knownKinds = map[string][2]string{
+	"deployment":  {"Deployment", "apps/v1"},
+	"statefulset": {"StatefulSet", "apps/v1"},
+	"daemonset":   {"DaemonSet", "apps/v1"},
+	"replicaset":  {"ReplicaSet", "apps/v1"},
+	"cronjob":     {"CronJob", "batch/v1"},
+	"job":         {"Job", "batch/v1"},
+	"ingress":     {"Ingress", "networking.k8s.io/v1"},
+	"service":     {"Service", "v1"},
+	"pod":         {"Pod", "v1"},
+	"namespace":   {"Namespace", "v1"},
+	"configmap":   {"ConfigMap", "v1"},
+}
+
+func migrateSelectors(root *yaml.Node) error {
+	imports := mappingValue(mappingValue(root, "service"), "import")
+	if imports == nil || imports.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for i, item := range imports.Content {
+		selector := mappingValue(item, "selector")
+		if selector == nil {
+			continue
+		}
+		if apiVersion := mappingValue(selector, "apiVersion"); apiVersion == nil || apiVersion.Value == "" {
+			kind := mappingValue(selector, "kind")
+			if kind == nil {
+				return fmt.Errorf("service.import[%d].selector has no kind", i)
+			}
+			known, ok := knownKinds[strings.ToLower(kind.Value)]
+			if !ok {
+				return fmt.Errorf("service.import[%d].selector cannot infer the apiVersion of kind '%s' - please set 'apiVersion'", i, kind.Value)
+			}
+			kind.Value = known[0]
+			setMappingValue(selector, "apiVersion", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: known[1]}, "kind")
+		}
+		if labels := mappingValue(selector, "labels"); labels != nil && labels.Kind == yaml.MappingNode {
+			list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for j := 0; j+1 < len(labels.Content); j += 2 {
+				list.Content = append(list.Content, &yaml.Node{
+					Kind:  yaml.ScalarNode,
+					Tag:   "!!str",
+					Value: labels.Content[j].Value + "=" + labels.Content[j+1].Value,
+				})
+			}
+			setMappingValue(selector, "labels", list, "")
+		}
+		if excludes := mappingValue(selector, "excludes"); excludes != nil && excludes.Kind == yaml.MappingNode {
+			list, err := migrateExcludes(excludes)
+			if err != nil {
+				return fmt.Errorf("service.import[%d].selector.excludes %w", i, err)
+			}
+			setMappingValue(selector, "excludes", list, "")
+		}
+	}
+	return nil
+}
+
+// migrateExcludes turns the 1.0.0 excluded 'namespaces' into jq expressions which exclude resources of those namespaces
+func migrateExcludes(excludes *yaml.Node) (*yaml.Node, error) {
+	list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for j := 0; j+1 < len(excludes.Content); j += 2 {
+		key, value := excludes.Content[j], excludes.Content[j+1]
+		if key.Value != "namespaces" || value.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("cannot migrate '%s' - please replace it with a list of jq expressions", key.Value)
+		}
+		for _, namespace := range value.Content {
+			quoted, err := json.Marshal(namespace.Value)
+			if err != nil {
+				return nil, err
+			}
+			list.Content = append(list.Content, &yaml.Node{
+				Kind:  yaml.ScalarNode,
+				Tag:   "!!str",
+				Value: fmt.Sprintf(".metadata.namespace == %s", quoted),
+			})
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,151 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config           string
+		expected         string
+		expectedVersions []string
+		expectedError    string
+	}
+	cases := map[string]TestCase{
+		"Current Version Is Unchanged": {
+			config:   common.ConfigSimple,
+			expected: common.ConfigSimple,
+		},
+		"1.0.0 Keeps Comments": {
+			config: `# deployed config
+version: "1.0.0"
+service:
+  import:
+    - selector: # what to import
+        kind: deployment
+        labels:
+          app: web
+      opslevel:
+        name: .metadata.name
+`,
+			expected: `# deployed config
+version: "1.3.0"
+service:
+  import:
+    - selector: # what to import
+        apiVersion: apps/v1
+        kind: Deployment
+        labels:
+          - app=web
+      opslevel:
+        name: .metadata.name
+`,
+			expectedVersions: []string{"1.1.0", "1.2.0", "1.3.0"},
+		},
+		"1.0.0 Excludes Become Expressions": {
+			config: `version: "1.0.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        excludes:
+          namespaces: # system namespaces
+            - kube-system
+            - local-path-storage
+`,
+			expected: `version: "1.3.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        excludes:
+          - .metadata.namespace == "kube-system"
+          - .metadata.namespace == "local-path-storage"
+`,
+			expectedVersions: []string{"1.1.0", "1.2.0", "1.3.0"},
+		},
+		"1.0.0 Unknown Excludes": {
+			config:        "version: \"1.0.0\"\nservice:\n  import:\n    - selector:\n        kind: Deployment\n        excludes:\n          kinds: [Pod]\n",
+			expectedError: "migrating config from '1.0.0' to '1.1.0': service.import[0].selector.excludes cannot migrate 'kinds' - please replace it with a list of jq expressions",
+		},
+		"1.1.0 Only Bumps The Version": {
+			config: `version: "1.1.0"
+service:
+  import:
+    - selector: # what to import
+        apiVersion: apps/v1
+        kind: Deployment
+        labels:
+          - app=web
+        excludes:
+          - .metadata.namespace == "kube-system"
+      opslevel:
+        name: .metadata.name
+`,
+			expected: `version: "1.3.0"
+service:
+  import:
+    - selector: # what to import
+        apiVersion: apps/v1
+        kind: Deployment
+        labels:
+          - app=web
+        excludes:
+          - .metadata.namespace == "kube-system"
+      opslevel:
+        name: .metadata.name
+`,
+			expectedVersions: []string{"1.2.0", "1.3.0"},
+		},
+		"1.2.0": {
+			config:           "version: \"1.2.0\"\nservice:\n  import: []\n",
+			expected:         "version: \"1.3.0\"\nservice:\n  import: []\n",
+			expectedVersions: []string{"1.3.0"},
+		},
+		"Unknown Kind": {
+			config:        "version: \"1.0.0\"\nservice:\n  import:\n    - selector:\n        kind: Widget\n",
+			expectedError: "migrating config from '1.0.0' to '1.1.0': service.import[0].selector cannot infer the apiVersion of kind 'Widget' - please set 'apiVersion'",
+		},
+		"Unknown Version": {
+			config:        "version: \"9.0.0\"\n",
+			expectedError: "supported config version is '1.3.0' but found '9.0.0' which cannot be migrated",
+		},
+		"Missing Version": {
+			config:        "service: {}\n",
+			expectedError: "could not parse version in the config file",
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		migrated, applied, err := common.MigrateConfig(test.config)
+		// Assert
+		if test.expectedError != "" {
+			autopilot.Equals(t, test.expectedError, err.Error())
+			return
+		}
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expected, migrated)
+		var versions []string
+		for _, migration := range applied {
+			versions = append(versions, migration.To)
+		}
+		autopilot.Equals(t, test.expectedVersions, versions)
+	})
+}
+
+func TestConfigMigrationsReachCurrentVersion(t *testing.T) {
+	// Arrange
+	last := common.ConfigMigrations[len(common.ConfigMigrations)-1]
+
+	// Assert
+	autopilot.Equals(t, common.ConfigCurrentVersion, last.To)
+	for i := 1; i < len(common.ConfigMigrations); i++ {
+		autopilot.Equals(t, common.ConfigMigrations[i-1].To, common.ConfigMigrations[i].From)
+	}
+}
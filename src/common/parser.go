@@ -5,33 +5,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/opslevel/opslevel-go/v2024"
 	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
-	opslevel_k8s_controller "github.com/opslevel/opslevel-k8s-controller/v2024"
 	"github.com/rs/zerolog/log"
 )
 
-func AggregateServices(queue <-chan opslevel_jq_parser.ServiceRegistration) *[]opslevel_jq_parser.ServiceRegistration {
+func AggregateServices(queue <-chan SourcedRegistration) *[]opslevel_jq_parser.ServiceRegistration {
 	services := make([]opslevel_jq_parser.ServiceRegistration, 0, 100)
 	for registration := range queue {
-		services = append(services, registration)
+		services = append(services, registration.ServiceRegistration)
 	}
 	return &services
 }
 
-func ReconcileServices(client *opslevel.Client, disableServiceCreation, enableServiceNameUpdate bool, queue <-chan opslevel_jq_parser.ServiceRegistration) {
-	reconciler := NewServiceReconciler(NewOpslevelClient(client), disableServiceCreation, enableServiceNameUpdate)
-	for registration := range queue {
-		err := reconciler.Reconcile(registration)
+// ReconcileServices reconciles every registration in the queue using a pool of workers until the queue is closed.
+// If a report is passed the result of every reconciliation is added to it.
+// If a requeuer is passed failed registrations are retried with backoff.
+func ReconcileServices(reconciler *ServiceReconciler, workers int, queue <-chan SourcedRegistration, report *ReconcileReport, requeuer *Requeuer) {
+	RunWorkers(workers, queue, func(worker int, registration SourcedRegistration) {
+		result, err := reconciler.ReconcileManaged(registration.ServiceRegistration, registration.Manage)
 		if err != nil {
-			log.Error().Err(err).Msg("failed when reconciling service")
+			log.Error().Err(err).Int("worker", worker).Msg("failed when reconciling service")
 		}
-	}
+		recordReconcile(result)
+		if report != nil {
+			report.Add(result)
+		}
+		if requeuer != nil {
+			if result.Failed() {
+				requeuer.Failed(registration)
+			} else {
+				requeuer.Succeeded(registration)
+			}
+		}
+	})
 }
 
-func NewParserHandler(config Import, queue chan<- opslevel_jq_parser.ServiceRegistration) func(interface{}) {
+// NewParserHandler returns a handler which parses kubernetes resources into registrations and sends them into the queue.
+// A registration which cannot be sent before the context is done is dropped.
+func NewParserHandler(ctx context.Context, config Import, queue chan<- SourcedRegistration) func(interface{}) {
+	return NewIndexedParserHandler(ctx, config, queue, nil, "")
+}
+
+// NewIndexedParserHandler is NewParserHandler which records every registration in the index before it is sent, during a
+// standby of the index registrations are only recorded.  The source of a registration is the prefix followed by the
+// namespace and name of its resource.
+func NewIndexedParserHandler(ctx context.Context, config Import, queue chan<- SourcedRegistration, index *RegistrationIndex, prefix string) func(interface{}) {
 	id := fmt.Sprintf("[%s/%s]", config.SelectorConfig.ApiVersion, config.SelectorConfig.Kind)
 
 	parser := opslevel_jq_parser.NewJQServiceParser(config.OpslevelConfig)
@@ -46,33 +67,77 @@ func NewParserHandler(config Import, queue chan<- opslevel_jq_parser.ServiceRegi
 			log.Error().Err(err).Msgf("%s - failed to parse k8s resource", id)
 			return
 		}
-		queue <- *registration
+		var resource struct {
+			Metadata struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+			} `json:"metadata"`
+		}
+		_ = json.Unmarshal(data, &resource)
+		sourced := SourcedRegistration{
+			ServiceRegistration: *registration,
+			Namespace:           resource.Metadata.Namespace,
+			Source:              registrationSource(prefix, resource.Metadata.Namespace, resource.Metadata.Name),
+			Manage:              config.Manage,
+		}
+		if !index.record(sourced) {
+			return
+		}
+		queueDepth.Inc()
+		select {
+		case <-ctx.Done():
+			queueDepth.Dec()
+		case queue <- sourced:
+		}
 	}
 }
 
-func SetupControllers(ctx context.Context, config *Config, queue chan<- opslevel_jq_parser.ServiceRegistration, resync time.Duration) {
+// SetupControllers starts a kubernetes controller for every import which records the registrations of its resources in
+// the index.  With a resync the controllers keep running, hand the registrations of deleted resources to the deletion
+// handlers and the returned runner updates them when the imports change, otherwise the controllers stop after a single
+// list.  The controllers are producers of the queue until they stopped.
+func SetupControllers(ctx context.Context, config *Config, producers *QueueProducers, index *RegistrationIndex, deletions *DeletionHandlers, resync time.Duration) *ImportRunner {
+	if resync > 0 {
+		runner := NewControllerRunner(ctx, producers, index, deletions, resync)
+		runner.Update(config.Service.Import)
+		return runner
+	}
+	if !producers.Add() {
+		return nil
+	}
 	go func() {
-		var wg *sync.WaitGroup
-		if resync <= 0 {
-			wg = &sync.WaitGroup{}
-		}
-		for _, importConfig := range config.Service.Import {
-			controller, err := opslevel_k8s_controller.NewK8SController(importConfig.SelectorConfig, resync)
+		defer producers.Done()
+		wg := &sync.WaitGroup{}
+		synced := atomic.Bool{}
+		synced.Store(true)
+		for i, importConfig := range config.Service.Import {
+			controller, err := NewImportController(importConfig, resync)
 			if err != nil {
 				log.Error().Err(err).Msg("failed to create k8s controller")
+				synced.Store(false)
 				continue
 			}
-			callback := NewParserHandler(importConfig, queue)
+			callback := NewIndexedParserHandler(ctx, importConfig, producers.queue, index, fmt.Sprintf("%d:", i))
 			controller.OnAdd = callback
 			controller.OnUpdate = callback
-			if wg != nil {
-				wg.Add(1)
-			}
-			controller.Start(ctx, wg)
-		}
-		if resync <= 0 {
-			wg.Wait()
-			close(queue)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// a single list, the informers are stopped as soon as every listed resource was handled
+				listCtx, cancel := context.WithCancel(ctx)
+				stopped, err := controller.Start(listCtx)
+				cancel()
+				if err != nil {
+					log.Error().Err(err).Msg("failed to list k8s resources")
+					synced.Store(false)
+				}
+				if stopped != nil {
+					<-stopped
+				}
+			}()
 		}
+		wg.Wait()
+		informersSynced.Store(synced.Load())
 	}()
+	return nil
 }
@@ -0,0 +1,245 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/opslevel/opslevel-go/v2024"
+	"github.com/rs/zerolog/log"
+)
+
+// PlannedChange is a single API write that would have been sent to OpsLevel
+type PlannedChange struct {
+	Service string       `json:"service"`
+	Action  ChangeAction `json:"action"`
+	Input   any          `json:"input"`
+}
+
+// PlannedServiceRepository describes a service repository link in the plan by its repository instead of only its id
+type PlannedServiceRepository struct {
+	Id            opslevel.ID `json:"id"`
+	Repository    string      `json:"repository"`
+	BaseDirectory string      `json:"baseDirectory"`
+}
+
+// Plan collects the changes a ServiceReconciler would make without applying them
+type Plan struct {
+	mutex     sync.Mutex
+	services  map[opslevel.ID]*opslevel.Service
+	owners    map[string]opslevel.ID // aliases, tag ids, tool ids and service repository ids to the service they belong to
+	links     map[opslevel.ID]PlannedServiceRepository
+	Changes   []PlannedChange        `json:"changes"`
+	Conflicts []RegistrationConflict `json:"conflicts,omitempty"`
+}
+
+func (p *Plan) track(service *opslevel.Service) {
+	if service == nil || service.Id == "" {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.services[service.Id] = service
+	for _, alias := range service.Aliases {
+		p.owners[alias] = service.Id
+	}
+	if service.Tags != nil {
+		for _, tag := range service.Tags.Nodes {
+			p.owners[string(tag.Id)] = service.Id
+		}
+	}
+	if service.Tools != nil {
+		for _, tool := range service.Tools.Nodes {
+			p.owners[string(tool.Id)] = service.Id
+		}
+	}
+	if service.Repositories != nil {
+		for _, edge := range service.Repositories.Edges {
+			for _, serviceRepository := range edge.ServiceRepositories {
+				p.owners[string(serviceRepository.Id)] = service.Id
+				p.links[serviceRepository.Id] = PlannedServiceRepository{
+					Id:            serviceRepository.Id,
+					Repository:    edge.Node.DefaultAlias,
+					BaseDirectory: serviceRepository.BaseDirectory,
+				}
+			}
+		}
+	}
+}
+
+func (p *Plan) trackRepository(repository *opslevel.Repository) {
+	if repository == nil || repository.Services == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, edge := range repository.Services.Edges {
+		for _, serviceRepository := range edge.ServiceRepositories {
+			p.owners[string(serviceRepository.Id)] = serviceRepository.Service.Id
+		}
+	}
+}
+
+func (p *Plan) get(id opslevel.ID) *opslevel.Service {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.services[id]
+}
+
+func (p *Plan) add(id *opslevel.ID, action ChangeAction, input any) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	name := ""
+	if id != nil {
+		if service, ok := p.services[*id]; ok {
+			name = service.Name
+		}
+	}
+	p.Changes = append(p.Changes, PlannedChange{Service: name, Action: action, Input: input})
+}
+
+func (p *Plan) addForOwner(owner string, action ChangeAction, input any) {
+	p.mutex.Lock()
+	id, ok := p.owners[owner]
+	p.mutex.Unlock()
+	if !ok {
+		p.add(nil, action, input)
+		return
+	}
+	p.add(&id, action, input)
+}
+
+// Services returns the number of distinct services that have changes
+func (p *Plan) Services() int {
+	names := map[string]bool{}
+	for _, change := range p.Changes {
+		names[change.Service] = true
+	}
+	return len(names)
+}
+
+// Sort orders the changes by service while keeping the order changes happened in for each service
+func (p *Plan) Sort() {
+	sort.SliceStable(p.Changes, func(i, j int) bool {
+		return p.Changes[i].Service < p.Changes[j].Service
+	})
+}
+
+// NewPlanOpslevelClient returns a read-only client which passes lookups through to OpsLevel
+// but records every write in the returned Plan instead of sending it.
+func NewPlanOpslevelClient(client *OpslevelClient) (*OpslevelClient, *Plan) {
+	plan := &Plan{
+		services: map[opslevel.ID]*opslevel.Service{},
+		owners:   map[string]opslevel.ID{},
+		links:    map[opslevel.ID]PlannedServiceRepository{},
+		Changes:  []PlannedChange{},
+	}
+	return &OpslevelClient{
+		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+			service, err := client.GetService(alias)
+			plan.track(service)
+			return service, err
+		},
+		CreateServiceHandler: func(input opslevel.ServiceCreateInput) (*opslevel.Service, error) {
+			// a placeholder service is returned so the remaining handlers can plan against it
+			service := &opslevel.Service{
+				ServiceId: opslevel.ServiceId{Id: opslevel.ID(fmt.Sprintf("planned:%s", input.Name))},
+				Name:      input.Name,
+				Tags:      &opslevel.TagConnection{},
+				Tools:     &opslevel.ToolConnection{},
+			}
+			plan.track(service)
+			plan.add(&service.Id, ChangeActionCreateService, input)
+			return service, nil
+		},
+		UpdateServiceHandler: func(input opslevel.ServiceUpdateInput) (*opslevel.Service, error) {
+			plan.add(input.Id, ChangeActionUpdateService, input)
+			if input.Id == nil {
+				return nil, nil
+			}
+			return plan.get(*input.Id), nil
+		},
+		DeleteServiceHandler: func(id opslevel.ID) error {
+			plan.add(&id, ChangeActionDeleteService, id)
+			return nil
+		},
+		CreateAliasHandler: func(input opslevel.AliasCreateInput) error {
+			plan.add(&input.OwnerId, ChangeActionCreateAlias, input.Alias)
+			return nil
+		},
+		DeleteAliasHandler: func(alias string) error {
+			plan.addForOwner(alias, ChangeActionDeleteAlias, alias)
+			return nil
+		},
+		AssignTagsHandler: func(service *opslevel.Service, tags map[string]string) error {
+			plan.add(&service.Id, ChangeActionAssignTags, tags)
+			return nil
+		},
+		GetPropertiesHandler: func(service *opslevel.Service) ([]opslevel.Property, error) {
+			return client.GetProperties(service)
+		},
+		AssignPropertyHandler: func(input opslevel.PropertyInput) error {
+			plan.add(input.Owner.Id, ChangeActionAssignProperty, input)
+			return nil
+		},
+		CreateTagHandler: func(input opslevel.TagCreateInput) error {
+			plan.add(input.Id, ChangeActionCreateTag, input)
+			return nil
+		},
+		DeleteTagHandler: func(id opslevel.ID) error {
+			plan.addForOwner(string(id), ChangeActionDeleteTag, id)
+			return nil
+		},
+		CreateToolHandler: func(tool opslevel.ToolCreateInput) error {
+			plan.add(tool.ServiceId, ChangeActionCreateTool, tool)
+			return nil
+		},
+		UpdateToolHandler: func(input opslevel.ToolUpdateInput) error {
+			plan.addForOwner(string(input.Id), ChangeActionUpdateTool, input)
+			return nil
+		},
+		DeleteToolHandler: func(id opslevel.ID) error {
+			plan.addForOwner(string(id), ChangeActionDeleteTool, id)
+			return nil
+		},
+		GetRepositoryWithAliasHandler: func(alias string) (*opslevel.Repository, error) {
+			repository, err := client.GetRepositoryWithAlias(alias)
+			plan.trackRepository(repository)
+			return repository, err
+		},
+		CreateServiceRepositoryHandler: func(input opslevel.ServiceRepositoryCreateInput) error {
+			plan.add(input.Service.Id, ChangeActionCreateServiceRepository, input)
+			return nil
+		},
+		UpdateServiceRepositoryHandler: func(input opslevel.ServiceRepositoryUpdateInput) error {
+			plan.addForOwner(string(input.Id), ChangeActionUpdateServiceRepository, input)
+			return nil
+		},
+		DeleteServiceRepositoryHandler: func(id opslevel.ID) error {
+			plan.mutex.Lock()
+			link, ok := plan.links[id]
+			plan.mutex.Unlock()
+			if !ok {
+				link = PlannedServiceRepository{Id: id}
+			}
+			plan.addForOwner(string(id), ChangeActionDeleteServiceRepository, link)
+			return nil
+		},
+	}, plan
+}
+
+// PlanServices runs the reconciler for every registration in the queue against a read-only client
+// and returns the changes that would have been made
+func PlanServices(reconciler *ServiceReconciler, workers int, queue <-chan SourcedRegistration) *Plan {
+	planClient, plan := NewPlanOpslevelClient(reconciler.client)
+	planner := *reconciler
+	planner.client = planClient
+	RunWorkers(workers, queue, func(worker int, registration SourcedRegistration) {
+		_, err := planner.ReconcileManaged(registration.ServiceRegistration, registration.Manage)
+		if err != nil {
+			log.Error().Err(err).Int("worker", worker).Msg("failed when planning service")
+		}
+	})
+	plan.Sort()
+	return plan
+}
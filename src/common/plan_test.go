@@ -0,0 +1,99 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func newPanickingWriteClient(getService func(alias string) (*opslevel.Service, error)) *common.OpslevelClient {
+	return &common.OpslevelClient{
+		GetServiceHandler: getService,
+		CreateServiceHandler: func(input opslevel.ServiceCreateInput) (*opslevel.Service, error) {
+			panic("should not be called")
+		},
+		UpdateServiceHandler: func(input opslevel.ServiceUpdateInput) (*opslevel.Service, error) {
+			panic("should not be called")
+		},
+		CreateAliasHandler: func(input opslevel.AliasCreateInput) error {
+			panic("should not be called")
+		},
+		AssignTagsHandler: func(service *opslevel.Service, tags map[string]string) error {
+			panic("should not be called")
+		},
+		CreateTagHandler: func(input opslevel.TagCreateInput) error {
+			panic("should not be called")
+		},
+		CreateToolHandler: func(tool opslevel.ToolCreateInput) error {
+			panic("should not be called")
+		},
+		AssignPropertyHandler: func(input opslevel.PropertyInput) error {
+			panic("should not be called")
+		},
+	}
+}
+
+func TestPlanNewService(t *testing.T) {
+	// Arrange
+	client, plan := common.NewPlanOpslevelClient(newPanickingWriteClient(func(alias string) (*opslevel.Service, error) {
+		return &opslevel.Service{}, nil
+	}))
+	reconciler := common.NewServiceReconciler(client, false, false)
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Name:       "new_service",
+		Aliases:    []string{"new_service"},
+		TagAssigns: []opslevel.TagInput{{Key: "foo", Value: "bar"}},
+		TagCreates: []opslevel.TagInput{{Key: "env", Value: "test"}},
+		Tools:      newToolInputs("A"),
+	}
+
+	// Act
+	_, err := reconciler.Reconcile(registration)
+
+	// Assert
+	autopilot.Ok(t, err)
+	actions := make([]common.ChangeAction, len(plan.Changes))
+	for i, change := range plan.Changes {
+		autopilot.Equals(t, "new_service", change.Service)
+		actions[i] = change.Action
+	}
+	autopilot.Equals(t, []common.ChangeAction{
+		common.ChangeActionCreateService,
+		common.ChangeActionCreateAlias,
+		common.ChangeActionAssignTags,
+		common.ChangeActionCreateTag,
+		common.ChangeActionCreateTool,
+	}, actions)
+	autopilot.Equals(t, 1, plan.Services())
+}
+
+func TestPlanExistingService(t *testing.T) {
+	// Arrange
+	service := opslevel.Service{
+		ServiceId: opslevel.ServiceId{Id: opslevel.ID("XXX"), Aliases: []string{"existing"}},
+		Name:      "existing",
+		Tags:      &opslevel.TagConnection{},
+		Tools:     &opslevel.ToolConnection{},
+	}
+	client, plan := common.NewPlanOpslevelClient(newPanickingWriteClient(func(alias string) (*opslevel.Service, error) {
+		return &service, nil
+	}))
+	reconciler := common.NewServiceReconciler(client, false, false)
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases:     []string{"existing"},
+		Description: "changed",
+	}
+
+	// Act
+	_, err := reconciler.Reconcile(registration)
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, 1, len(plan.Changes))
+	autopilot.Equals(t, "existing", plan.Changes[0].Service)
+	autopilot.Equals(t, common.ChangeActionUpdateService, plan.Changes[0].Action)
+	autopilot.Equals(t, "changed", *plan.Changes[0].Input.(opslevel.ServiceUpdateInput).Description)
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 
 	"golang.org/x/exp/maps"
@@ -27,6 +28,13 @@ type ServiceReconciler struct {
 	client                  *OpslevelClient
 	disableServiceCreation  bool
 	enableServiceNameUpdate bool
+	managedAliases          ManagedAliases
+	managedTags             ManagedTags
+	managedTools            ManagedTools
+	managedRepositories     ManagedRepositories
+	repositoryMatch         RepositoryMatch
+	emptyValues             EmptyValuesConfig
+	index                   *RegistrationIndex
 }
 
 func NewServiceReconciler(client *OpslevelClient, disableServiceCreation, enableServiceNameUpdate bool) *ServiceReconciler {
@@ -37,26 +45,49 @@ func NewServiceReconciler(client *OpslevelClient, disableServiceCreation, enable
 	}
 }
 
-func (r *ServiceReconciler) Reconcile(registration opslevel_jq_parser.ServiceRegistration) error {
+// Reconcile ensures the service described by the registration exists in OpsLevel with all of its data.
+// The returned result describes every API write that was attempted, errors are only returned if the service itself could not be reconciled.
+func (r *ServiceReconciler) Reconcile(registration opslevel_jq_parser.ServiceRegistration) (*ReconcileResult, error) {
+	return r.ReconcileManaged(registration, ManageConfig{})
+}
+
+// ReconcileManaged is Reconcile which only sets the fields of the service the manage config allows
+func (r *ServiceReconciler) ReconcileManaged(registration opslevel_jq_parser.ServiceRegistration, manage ManageConfig) (*ReconcileResult, error) {
+	result := &ReconcileResult{Service: registration.Name, Aliases: registration.Aliases}
 	if len(registration.Aliases) <= 0 {
-		return fmt.Errorf("[%s] found 0 aliases from kubernetes data", registration.Name)
+		err := fmt.Errorf("[%s] found 0 aliases from kubernetes data", registration.Name)
+		result.Action, result.Error = ReconcileActionFailed, err.Error()
+		return result, err
 	}
-	service, err := r.handleService(registration)
+	service, err := r.handleService(registration, manage, result)
 	if err != nil {
-		return err
+		result.Action, result.Error = ReconcileActionFailed, err.Error()
+		return result, err
 	}
 	if service == nil {
-		return nil
+		result.Action = ReconcileActionSkipped
+		return result, nil
 	}
+	result.Service = service.Name
 
-	// We don't care about errors at this point because they will just be logged
-	r.handleAliases(service, registration)
-	r.handleAssignTags(service, registration)
-	r.handleCreateTags(service, registration)
-	r.handleTools(service, registration)
-	r.handleRepositories(service, registration)
-	r.handleProperties(service, registration)
-	return nil
+	// Errors at this point are logged and recorded in the result
+	mapped := r.mappedRegistrations(service, registration)
+	r.handleAliases(service, registration, result)
+	r.handlePruneAliases(service, mapped, result)
+	r.handlePruneTags(service, mapped, result)
+	r.handleAssignTags(service, registration, result)
+	r.handleCreateTags(service, registration, result)
+	r.handleTools(service, registration, result)
+	r.handlePruneTools(service, mapped, result)
+	linked, complete := r.handleRepositories(service, registration, result)
+	if complete && r.handleMappedRepositories(service, mapped[1:], linked) {
+		r.handlePruneRepositories(service, linked, result)
+	}
+	r.handleProperties(service, registration, result)
+	if result.Action == ReconcileActionUnchanged && len(result.Operations) > 0 {
+		result.Action = ReconcileActionUpdated
+	}
+	return result, nil
 }
 
 func (r *ServiceReconciler) ContainsAllTags(tagAssigns []opslevel.TagInput, serviceTags []opslevel.Tag) bool {
@@ -84,7 +115,7 @@ func (r *ServiceReconciler) ContainsAllTags(tagAssigns []opslevel.TagInput, serv
 // serviceAliasesResult_MultipleServicesFound - means that all API calls succeeded but multiple services were returning means the list of aliases does not definitively describe a single service and might be a configuration problem
 // serviceAliasesResult_APIErrorHappened - means that 1 of N aliases got a 4xx/5xx and thereforce we cannot say 100% that the services doesn't exist
 // serviceAliasesResult_FoundServiceNoAlias - means that a service was found but that service has no alias (this should not be possible and can only happen from a bad code change.)
-func (r *ServiceReconciler) lookupService(registration opslevel_jq_parser.ServiceRegistration) (*opslevel.Service, serviceAliasesResult) {
+func (r *ServiceReconciler) lookupService(registration opslevel_jq_parser.ServiceRegistration) (*opslevel.Service, serviceAliasesResult, error) {
 	var gotError error
 	foundServices := map[string]*opslevel.Service{}
 	for _, alias := range registration.Aliases {
@@ -98,7 +129,7 @@ func (r *ServiceReconciler) lookupService(registration opslevel_jq_parser.Servic
 			if len(foundService.Aliases) == 1 {
 				// If this happens and there is only 1 alias to check we cannot assume the service doesn't exist
 				// because it seems like our API has a race condition looking up the service
-				return nil, serviceAliasesResult_APIErrorHappened
+				return nil, serviceAliasesResult_APIErrorHappened, nil
 			}
 			log.Warn().Msgf("unexpected happened: got service with alias '%s' but the result has no ID", alias)
 		} else {
@@ -107,25 +138,25 @@ func (r *ServiceReconciler) lookupService(registration opslevel_jq_parser.Servic
 		}
 	}
 	if gotError != nil {
-		return nil, serviceAliasesResult_APIErrorHappened
+		return nil, serviceAliasesResult_APIErrorHappened, gotError
 	}
 	foundServicesCount := len(foundServices)
 	if foundServicesCount == 1 {
 		keys := maps.Keys(foundServices)
 		if len(keys) == 0 {
-			return nil, serviceAliasesResult_FoundServiceNoAlias
+			return nil, serviceAliasesResult_FoundServiceNoAlias, nil
 		}
 		key := keys[0]
-		return foundServices[key], serviceAliasesResult_AliasMatched
+		return foundServices[key], serviceAliasesResult_AliasMatched, nil
 	} else if foundServicesCount > 1 {
-		return nil, serviceAliasesResult_MultipleServicesFound
+		return nil, serviceAliasesResult_MultipleServicesFound, nil
 	} else {
-		return nil, serviceAliasesResult_NoAliasesMatched
+		return nil, serviceAliasesResult_NoAliasesMatched, nil
 	}
 }
 
-func (r *ServiceReconciler) handleService(registration opslevel_jq_parser.ServiceRegistration) (*opslevel.Service, error) {
-	service, status := r.lookupService(registration)
+func (r *ServiceReconciler) handleService(registration opslevel_jq_parser.ServiceRegistration, manage ManageConfig, result *ReconcileResult) (*opslevel.Service, error) {
+	service, status, lookupErr := r.lookupService(registration)
 	switch status {
 	case serviceAliasesResult_NoAliasesMatched:
 		if r.disableServiceCreation {
@@ -133,13 +164,16 @@ func (r *ServiceReconciler) handleService(registration opslevel_jq_parser.Servic
 			return nil, nil
 		}
 
-		newService, newServiceErr := r.createService(registration)
+		newService, newServiceErr := r.createService(registration, manage)
+		result.Record(ChangeActionCreateService, registration.Name, newServiceErr)
 		if newServiceErr != nil {
 			return nil, fmt.Errorf("[%s] api error during service creation ... skipping reconciliation.\n\tREASON: %v", registration.Name, newServiceErr)
 		}
 		service = newService
+		result.Action = ReconcileActionCreated
 	case serviceAliasesResult_AliasMatched:
-		r.updateService(service, registration)
+		result.Action = ReconcileActionUnchanged
+		r.updateService(service, registration, manage, result)
 	case serviceAliasesResult_MultipleServicesFound:
 		aliases := ""
 		if service != nil {
@@ -147,6 +181,9 @@ func (r *ServiceReconciler) handleService(registration opslevel_jq_parser.Servic
 		}
 		return nil, fmt.Errorf("[%s] found multiple services with aliases = [%s].  cannot know which service to target for update ... skipping reconciliation", registration.Name, aliases)
 	case serviceAliasesResult_APIErrorHappened:
+		if lookupErr != nil {
+			return nil, fmt.Errorf("[%s] api error during service lookup by alias.  unable to guarantee service was found or not ... skipping reconciliation\n\tREASON: %v", registration.Name, lookupErr)
+		}
 		return nil, fmt.Errorf("[%s] api error during service lookup by alias.  unable to guarantee service was found or not ... skipping reconciliation", registration.Name)
 	case serviceAliasesResult_FoundServiceNoAlias:
 		return nil, fmt.Errorf("[%s] found matching service but it unexpectedly has no alias.  please submit a bug report. ... skipping reconciliation", registration.Name)
@@ -154,7 +191,13 @@ func (r *ServiceReconciler) handleService(registration opslevel_jq_parser.Servic
 	return service, nil
 }
 
-func (r *ServiceReconciler) createService(registration opslevel_jq_parser.ServiceRegistration) (*opslevel.Service, error) {
+func (r *ServiceReconciler) createService(registration opslevel_jq_parser.ServiceRegistration, manage ManageConfig) (*opslevel.Service, error) {
+	// fields which are never managed are left empty, the name is always set since a service cannot be created without it
+	for _, field := range scalarFields {
+		if field != "name" && !manage.OnCreate(field) {
+			*scalarField(&registration, field) = ""
+		}
+	}
 	serviceCreateInput := opslevel.ServiceCreateInput{
 		Name:        registration.Name,
 		Product:     opslevel.RefOf[string](registration.Product),
@@ -205,7 +248,7 @@ func (r *ServiceReconciler) createService(registration opslevel_jq_parser.Servic
 
 // updateService uses compares each field (not foreign keys like Tools or Tags) value in the registration vs the value that is currently set on the service.
 // if there are any updates needed, it will send a ServiceUpdateInput to the API.
-func (r *ServiceReconciler) updateService(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+func (r *ServiceReconciler) updateService(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, manage ManageConfig, result *ReconcileResult) {
 	if service == nil {
 		log.Warn().Msgf("[%s] unexpected happened: service passed to be updated is nil", registration.Name)
 		return
@@ -218,16 +261,21 @@ func (r *ServiceReconciler) updateService(service *opslevel.Service, registratio
 	// some fields like System need special comparisons, e.g. by using systemIdHasAlias
 	// only purpose of cmp.Diff is to display an easy-to-read diff for the user to understand what happened and to check if there
 	// is a need to submit an API update request, since the output of cmp.Diff is not really parseable.
-	if registration.Description != "" && registration.Description != service.Description {
+	// empty values are skipped unless the field is authoritative, then the empty string is sent which unsets the field
+	// fields which are only managed on create or never are not updated at all
+	if manage.OnUpdate("description") && (registration.Description != "" || r.emptyValues.Clears("description")) && registration.Description != service.Description {
 		updateServiceInput.Description = opslevel.RefOf(registration.Description)
 	}
-	if registration.Framework != "" && registration.Framework != service.Framework {
+	if manage.OnUpdate("framework") && (registration.Framework != "" || r.emptyValues.Clears("framework")) && registration.Framework != service.Framework {
 		updateServiceInput.Framework = opslevel.RefOf(registration.Framework)
 	}
-	if registration.Language != "" && registration.Language != service.Language {
+	if manage.OnUpdate("language") && (registration.Language != "" || r.emptyValues.Clears("language")) && registration.Language != service.Language {
 		updateServiceInput.Language = opslevel.RefOf(registration.Language)
 	}
-	if registration.Lifecycle != "" && registration.Lifecycle != service.Lifecycle.Alias {
+	if manage.OnUpdate("lifecycle") && registration.Lifecycle == "" && r.emptyValues.Clears("lifecycle") && service.Lifecycle.Alias != "" {
+		updateServiceInput.LifecycleAlias = opslevel.RefOf("")
+	}
+	if manage.OnUpdate("lifecycle") && registration.Lifecycle != "" && registration.Lifecycle != service.Lifecycle.Alias {
 		if lifecycle, ok := opslevel.Cache.TryGetLifecycle(registration.Lifecycle); ok {
 			if lifecycle == nil {
 				err := fmt.Errorf("the cache unexpectedly returned a lifecycle that is nil - please submit a bug report")
@@ -239,10 +287,10 @@ func (r *ServiceReconciler) updateService(service *opslevel.Service, registratio
 			log.Warn().Msgf("[%s] Unable to find 'Lifecycle' with alias '%s'", service.Name, registration.Lifecycle)
 		}
 	}
-	if r.enableServiceNameUpdate && registration.Name != "" && registration.Name != service.Name {
+	if r.enableServiceNameUpdate && manage.OnUpdate("name") && registration.Name != "" && registration.Name != service.Name {
 		updateServiceInput.Name = opslevel.RefOf(registration.Name)
 	}
-	if registration.Owner != "" && registration.Owner != service.Owner.Alias {
+	if manage.OnUpdate("owner") && registration.Owner != "" && registration.Owner != service.Owner.Alias {
 		if team, ok := opslevel.Cache.TryGetTeam(registration.Owner); ok {
 			if team == nil {
 				err := fmt.Errorf("the cache unexpectedly returned a team that is nil - please submit a bug report")
@@ -255,13 +303,16 @@ func (r *ServiceReconciler) updateService(service *opslevel.Service, registratio
 		}
 	}
 	// TODO: use the opslevel-go system cache here once it is added
-	if registration.System != "" && !systemIdHasAlias(service.Parent, registration.System) {
+	if manage.OnUpdate("system") && registration.System != "" && !systemIdHasAlias(service.Parent, registration.System) {
 		updateServiceInput.Parent = opslevel.NewIdentifier(registration.System)
 	}
-	if registration.Product != "" && registration.Product != service.Product {
+	if manage.OnUpdate("product") && (registration.Product != "" || r.emptyValues.Clears("product")) && registration.Product != service.Product {
 		updateServiceInput.Product = opslevel.RefOf(registration.Product)
 	}
-	if registration.Tier != "" && registration.Tier != service.Tier.Alias {
+	if manage.OnUpdate("tier") && registration.Tier == "" && r.emptyValues.Clears("tier") && service.Tier.Alias != "" {
+		updateServiceInput.TierAlias = opslevel.RefOf("")
+	}
+	if manage.OnUpdate("tier") && registration.Tier != "" && registration.Tier != service.Tier.Alias {
 		if tier, ok := opslevel.Cache.TryGetTier(registration.Tier); ok {
 			if tier == nil {
 				err := fmt.Errorf("the cache unexpectedly returned a tier that is nil - please submit a bug report")
@@ -281,6 +332,7 @@ func (r *ServiceReconciler) updateService(service *opslevel.Service, registratio
 	updateJSON, _ := json.Marshal(updateServiceInput)
 	log.Info().Msgf("[%s] Detected Changes - Sending Update:\n%s", service.Name, string(updateJSON))
 	updatedService, updateServiceErr := r.client.UpdateService(updateServiceInput)
+	result.Record(ChangeActionUpdateService, service.Name, updateServiceErr)
 	if updateServiceErr != nil {
 		log.Error().Msgf("[%s] Failed updating service\n\tREASON: %v", service.Name, updateServiceErr.Error())
 		return
@@ -290,10 +342,11 @@ func (r *ServiceReconciler) updateService(service *opslevel.Service, registratio
 		return
 	}
 	serviceDiff := cmp.Diff(service, updatedService)
+	result.Diff = serviceDiff
 	log.Info().Msgf("[%s] Updated Service - Diff:\n%s", service.Name, serviceDiff)
 }
 
-func (r *ServiceReconciler) handleAliases(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+func (r *ServiceReconciler) handleAliases(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
 	for _, alias := range registration.Aliases {
 		if alias == "" || service.HasAlias(alias) {
 			continue
@@ -302,6 +355,7 @@ func (r *ServiceReconciler) handleAliases(service *opslevel.Service, registratio
 			Alias:   alias,
 			OwnerId: service.Id,
 		})
+		result.Record(ChangeActionCreateAlias, alias, err)
 		if err != nil {
 			log.Error().Msgf("[%s] Failed assigning alias '%s'\n\tREASON: %v", service.Name, alias, err.Error())
 		} else {
@@ -310,7 +364,7 @@ func (r *ServiceReconciler) handleAliases(service *opslevel.Service, registratio
 	}
 }
 
-func (r *ServiceReconciler) handleAssignTags(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+func (r *ServiceReconciler) handleAssignTags(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
 	if registration.TagAssigns == nil {
 		return
 	}
@@ -322,6 +376,7 @@ func (r *ServiceReconciler) handleAssignTags(service *opslevel.Service, registra
 
 		err := r.client.AssignTags(service, tags)
 		jsonBytes, _ := json.Marshal(registration.TagAssigns)
+		result.Record(ChangeActionAssignTags, string(jsonBytes), err)
 		if err != nil {
 			log.Error().Msgf("[%s] Failed assigning tags: %s\n\tREASON: %v", service.Name, string(jsonBytes), err.Error())
 		} else {
@@ -332,7 +387,7 @@ func (r *ServiceReconciler) handleAssignTags(service *opslevel.Service, registra
 	}
 }
 
-func (r *ServiceReconciler) handleCreateTags(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+func (r *ServiceReconciler) handleCreateTags(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
 	for _, tag := range registration.TagCreates {
 		if service.HasTag(tag.Key, tag.Value) {
 			continue
@@ -343,6 +398,7 @@ func (r *ServiceReconciler) handleCreateTags(service *opslevel.Service, registra
 			Value: tag.Value,
 		}
 		err := r.client.CreateTag(input)
+		result.Record(ChangeActionCreateTag, fmt.Sprintf("%s = %s", tag.Key, tag.Value), err)
 		if err != nil {
 			log.Error().Msgf("[%s] Failed creating tag '%s = %s'\n\tREASON: %v", service.Name, tag.Key, tag.Value, err.Error())
 		} else {
@@ -351,18 +407,41 @@ func (r *ServiceReconciler) handleCreateTags(service *opslevel.Service, registra
 	}
 }
 
-func (r *ServiceReconciler) handleTools(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+func findTool(service *opslevel.Service, category opslevel.ToolCategory, name string, environment string) *opslevel.Tool {
+	if service.Tools == nil {
+		return nil
+	}
+	for i, tool := range service.Tools.Nodes {
+		if tool.Category == category && tool.DisplayName == name && tool.Environment == environment {
+			return &service.Tools.Nodes[i]
+		}
+	}
+	return nil
+}
+
+func (r *ServiceReconciler) handleTools(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
 	for _, tool := range registration.Tools {
 		toolEnv := ""
 		if tool.Environment != nil {
 			toolEnv = *tool.Environment
 		}
-		if service.HasTool(tool.Category, tool.DisplayName, toolEnv) {
-			log.Debug().Msgf("[%s] Tool '{Category: %s, Environment: %s, Name: %s}' already exists on service ... skipping", service.Name, tool.Category, toolEnv, tool.DisplayName)
+		if existing := findTool(service, tool.Category, tool.DisplayName, toolEnv); existing != nil {
+			if existing.Url == tool.Url {
+				log.Debug().Msgf("[%s] Tool '{Category: %s, Environment: %s, Name: %s}' already exists on service ... skipping", service.Name, tool.Category, toolEnv, tool.DisplayName)
+				continue
+			}
+			err := r.client.UpdateTool(opslevel.ToolUpdateInput{Id: existing.Id, Url: opslevel.RefOf(tool.Url)})
+			result.Record(ChangeActionUpdateTool, tool.DisplayName, err)
+			if err != nil {
+				log.Error().Msgf("[%s] Failed updating tool '{Category: %s, Environment: %s, Name: %s}' url to '%s'\n\tREASON: %v", service.Name, tool.Category, toolEnv, tool.DisplayName, tool.Url, err.Error())
+			} else {
+				log.Info().Msgf("[%s] Updated tool '{Category: %s, Environment: %s, Name: %s}' url to '%s'", service.Name, tool.Category, toolEnv, tool.DisplayName, tool.Url)
+			}
 			continue
 		}
 		tool.ServiceId = &service.Id
 		err := r.client.CreateTool(tool)
+		result.Record(ChangeActionCreateTool, tool.DisplayName, err)
 		if err != nil {
 			log.Error().Msgf("[%s] Failed assigning tool '{Category: %s, Environment: %s, Name: %s}'\n\tREASON: %v", service.Name, tool.Category, toolEnv, tool.DisplayName, err.Error())
 		} else {
@@ -371,7 +450,36 @@ func (r *ServiceReconciler) handleTools(service *opslevel.Service, registration
 	}
 }
 
-func (r *ServiceReconciler) handleRepositories(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+// onlyServiceRepository returns the link of the repository to the service if there is exactly one
+func onlyServiceRepository(repository *opslevel.Repository, service opslevel.ID) *opslevel.ServiceRepository {
+	var found *opslevel.ServiceRepository
+	if repository.Services == nil {
+		return nil
+	}
+	for _, edge := range repository.Services.Edges {
+		for i, connection := range edge.ServiceRepositories {
+			if connection.Service.Id != service {
+				continue
+			}
+			if found != nil {
+				return nil
+			}
+			found = &edge.ServiceRepositories[i]
+		}
+	}
+	return found
+}
+
+// handleRepositories links the repositories of the registration to the service and returns every link it wants by
+// repository id and base directory.  complete is false if any repository could not be looked up.
+func (r *ServiceReconciler) handleRepositories(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) (linked map[string]bool, complete bool) {
+	linked, complete = map[string]bool{}, true
+	links := map[string]int{}
+	for _, inputRepository := range registration.Repositories {
+		if inputRepository.Repository.Alias != nil {
+			links[*inputRepository.Repository.Alias]++
+		}
+	}
 	for _, inputRepository := range registration.Repositories {
 		if inputRepository.Repository.Alias == nil || *inputRepository.Repository.Alias == "null" || *inputRepository.Repository.Alias == "" {
 			continue
@@ -389,23 +497,39 @@ func (r *ServiceReconciler) handleRepositories(service *opslevel.Service, regist
 		// look up the repository in OpsLevel - exit if it does not exist
 		foundRepository, foundRepositoryErr := r.client.GetRepositoryWithAlias(*inputRepository.Repository.Alias)
 		if foundRepositoryErr != nil {
+			result.Record(ChangeActionLookupRepository, *inputRepository.Repository.Alias, foundRepositoryErr)
 			repoLogger.Error().Err(foundRepositoryErr).Msgf("fetching repository in OpsLevel resulted in an error ... skipping")
+			complete = false
 			continue
 		} else if foundRepository == nil {
 			repoLogger.Warn().Msgf("repository not found in OpsLevel ... skipping")
 			continue
 		}
+		linked[serviceRepositoryKey(foundRepository.Id, *inputRepository.BaseDirectory)] = true
 
 		// look up the ServiceRepository matching the base directory
 		serviceRepository := foundRepository.GetService(service.Id, *inputRepository.BaseDirectory)
+		moved := false
+		if serviceRepository == nil && r.repositoryMatch == RepositoryMatchRepository && links[*inputRepository.Repository.Alias] == 1 {
+			// the repository is the identity of the link, so its only link moves to the new base directory
+			serviceRepository = onlyServiceRepository(foundRepository, service.Id)
+			moved = serviceRepository != nil
+		}
 
-		// if the ServiceRepository is found, update the fields on the ServiceRepository (currently just the display name)
+		// if the ServiceRepository is found, update the fields on the ServiceRepository (display name and a moved base directory)
 		if serviceRepository != nil {
 			repoLogger.Debug().Msgf("found service repository (%s) has display name: '%s'", serviceRepository.Id, serviceRepository.DisplayName)
 
 			// check to ensure that an update call is actually necessary
 			repositoryUpdate := opslevel.ServiceRepositoryUpdateInput{Id: serviceRepository.Id, BaseDirectory: &serviceRepository.BaseDirectory}
 			needsUpdate := false
+			if moved {
+				// keep the link from being pruned with the base directory the service still has
+				linked[serviceRepositoryKey(foundRepository.Id, serviceRepository.BaseDirectory)] = true
+				repoLogger.Info().Msgf("moving service repository (%s) from base directory '%s'", serviceRepository.Id, serviceRepository.BaseDirectory)
+				repositoryUpdate.BaseDirectory = inputRepository.BaseDirectory
+				needsUpdate = true
+			}
 			if inputRepository.DisplayName != nil && *inputRepository.DisplayName != "" && *inputRepository.DisplayName != serviceRepository.DisplayName {
 				repositoryUpdate.DisplayName = inputRepository.DisplayName
 				needsUpdate = true
@@ -417,6 +541,7 @@ func (r *ServiceReconciler) handleRepositories(service *opslevel.Service, regist
 
 			// perform update
 			serviceRepositoryUpdateErr := r.client.UpdateServiceRepository(repositoryUpdate)
+			result.Record(ChangeActionUpdateServiceRepository, *inputRepository.Repository.Alias, serviceRepositoryUpdateErr)
 			if serviceRepositoryUpdateErr != nil {
 				repoLogger.Error().Err(serviceRepositoryUpdateErr).Msgf("failed updating service repository (%s)", serviceRepository.Id)
 				continue
@@ -428,21 +553,66 @@ func (r *ServiceReconciler) handleRepositories(service *opslevel.Service, regist
 		// if the ServiceRepository is not found, create the ServiceRepository (assign the repository to the current service)
 		inputRepository.Service = opslevel.IdentifierInput{Id: &service.Id}
 		err := r.client.CreateServiceRepository(inputRepository)
+		result.Record(ChangeActionCreateServiceRepository, *inputRepository.Repository.Alias, err)
 		if err != nil {
 			repoLogger.Error().Err(err).Msgf("failed creating a new service repository")
 			continue
 		}
 		repoLogger.Info().Msgf("successfully created a new service repository")
 	}
+	return linked, complete
+}
+
+// currentProperties fetches the property values already assigned to the service keyed by every alias and id of their definition
+func (r *ServiceReconciler) currentProperties(service *opslevel.Service, result *ReconcileResult) map[string]*opslevel.JsonString {
+	current := map[string]*opslevel.JsonString{}
+	if result.Action == ReconcileActionCreated {
+		return current
+	}
+	properties, err := r.client.GetProperties(service)
+	if err != nil {
+		log.Warn().Err(err).Msgf("[%s] Failed fetching current properties ... assigning all properties", service.Name)
+		return current
+	}
+	for _, property := range properties {
+		current[string(property.Definition.Id)] = property.Value
+		for _, alias := range property.Definition.Aliases {
+			current[alias] = property.Value
+		}
+	}
+	return current
 }
 
-func (r *ServiceReconciler) handleProperties(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration) {
+// propertyValueEqual compares two property values as normalized JSON falling back to comparing them as strings
+func propertyValueEqual(current *opslevel.JsonString, desired opslevel.JsonString) bool {
+	if current == nil {
+		return false
+	}
+	var currentValue, desiredValue any
+	if json.Unmarshal([]byte(*current), &currentValue) != nil || json.Unmarshal([]byte(desired), &desiredValue) != nil {
+		return string(*current) == string(desired)
+	}
+	return reflect.DeepEqual(currentValue, desiredValue)
+}
+
+func (r *ServiceReconciler) handleProperties(service *opslevel.Service, registration opslevel_jq_parser.ServiceRegistration, result *ReconcileResult) {
+	if len(registration.Properties) == 0 {
+		return
+	}
+	current := r.currentProperties(service, result)
 	for _, propertyInput := range registration.Properties {
 		if propertyInput.Definition.Alias == nil {
 			log.Warn().Msgf("[%s] Cannot assign property with no definition ... skipping", service.Name)
+			continue
+		}
+		if value, ok := current[*propertyInput.Definition.Alias]; ok && propertyValueEqual(value, propertyInput.Value) {
+			result.UnchangedProperties = append(result.UnchangedProperties, *propertyInput.Definition.Alias)
+			log.Debug().Msgf("[%s] Property with definition: '%s' already has value: '%s' ... skipping", service.Name, *propertyInput.Definition.Alias, propertyInput.Value)
+			continue
 		}
 		propertyInput.Owner = *opslevel.NewIdentifier(string(service.Id))
-		err := r.client.AssignPropertyHandler(propertyInput)
+		err := r.client.AssignProperty(propertyInput)
+		result.Record(ChangeActionAssignProperty, *propertyInput.Definition.Alias, err)
 		if err != nil {
 			log.Error().Err(err).Msgf("[%s] Failed assigning property with definition: '%s' and value: '%s'", service.Name, *propertyInput.Definition.Alias, propertyInput.Value)
 			continue
@@ -135,7 +135,7 @@ func TestReconcilerReconcile(t *testing.T) {
 				},
 			}, false, true),
 			assert: func(t *testing.T, err error) {
-				autopilot.Equals(t, "[test] api error during service lookup by alias.  unable to guarantee service was found or not ... skipping reconciliation", err.Error())
+				autopilot.Equals(t, "[test] api error during service lookup by alias.  unable to guarantee service was found or not ... skipping reconciliation\n\tREASON: api error", err.Error())
 			},
 		},
 		"API Error On Create Service Should Halt": {
@@ -425,7 +425,8 @@ func TestReconcilerReconcile(t *testing.T) {
 	// Act
 	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
 		// Assert
-		test.assert(t, test.reconciler.Reconcile(test.registration))
+		_, err := test.reconciler.Reconcile(test.registration)
+		test.assert(t, err)
 	})
 }
 
@@ -482,7 +483,7 @@ func Test_Reconciler_RepoNotInOpsLevel(t *testing.T) {
 			panic("should not be called")
 		},
 	}, true, true)
-	reconcilerError := reconciler.Reconcile(testRegistration)
+	_, reconcilerError := reconciler.Reconcile(testRegistration)
 
 	autopilot.Ok(t, reconcilerError)
 	autopilot.Assert(t, calledGetRepositoryWithAliasHandler, "expected call to GetRepositoryWithAliasHandler")
@@ -552,7 +553,7 @@ func Test_Reconciler_RepoIsAttached(t *testing.T) {
 			panic("should not be called")
 		},
 	}, true, true)
-	reconcilerError := reconciler.Reconcile(testRegistration)
+	_, reconcilerError := reconciler.Reconcile(testRegistration)
 
 	autopilot.Ok(t, reconcilerError)
 	autopilot.Assert(t, calledGetRepositoryWithAliasHandler, "expected call to GetRepositoryWithAliasHandler")
@@ -620,7 +621,7 @@ func Test_Reconciler_RepoNeedsCreate(t *testing.T) {
 			panic("should not be called")
 		},
 	}, true, true)
-	reconcilerError := reconciler.Reconcile(testRegistration)
+	_, reconcilerError := reconciler.Reconcile(testRegistration)
 
 	autopilot.Ok(t, reconcilerError)
 	autopilot.Assert(t, calledGetRepositoryWithAliasHandler, "expected call to GetRepositoryWithAliasHandler")
@@ -691,7 +692,7 @@ func Test_Reconciler_RepoNeedsCreateBasic(t *testing.T) {
 			panic("should not be called")
 		},
 	}, true, true)
-	reconcilerError := reconciler.Reconcile(testRegistration)
+	_, reconcilerError := reconciler.Reconcile(testRegistration)
 
 	autopilot.Ok(t, reconcilerError)
 	autopilot.Assert(t, calledGetRepositoryWithAliasHandler, "expected call to GetRepositoryWithAliasHandler")
@@ -771,7 +772,7 @@ func Test_Reconciler_RepoNeedsUpdate(t *testing.T) {
 			return nil
 		},
 	}, true, true)
-	reconcilerError := reconciler.Reconcile(testRegistration)
+	_, reconcilerError := reconciler.Reconcile(testRegistration)
 
 	autopilot.Ok(t, reconcilerError)
 	autopilot.Assert(t, calledGetRepositoryWithAliasHandler, "expected call to GetRepositoryWithAliasHandler")
@@ -902,7 +903,7 @@ func Test_Reconciler_HandleTools(t *testing.T) {
 		},
 	}, false, true)
 	// Act
-	err := reconciler.Reconcile(registration)
+	_, err := reconciler.Reconcile(registration)
 	autopilot.Ok(t, err)
 	// Assert
 	autopilot.Assert(t, len(toolsCreated) == 2 && toolsCreated[0].DisplayName == "F" &&
@@ -954,7 +955,7 @@ func Test_Reconciler_HandleProperties(t *testing.T) {
 	}, false, true)
 
 	// Act
-	err := reconciler.Reconcile(registration)
+	_, err := reconciler.Reconcile(registration)
 	autopilot.Ok(t, err)
 
 	// Assert
@@ -968,6 +969,85 @@ func Test_Reconciler_HandleProperties(t *testing.T) {
 	}
 }
 
+func Test_Reconciler_HandlePropertiesWithoutDefinitionAlias(t *testing.T) {
+	// Arrange
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases: []string{"test"},
+		Name:    "test",
+		Properties: []opslevel.PropertyInput{
+			{Definition: opslevel.IdentifierInput{}, Value: opslevel.JsonString("true")},
+			{Definition: *opslevel.NewIdentifier("prop_bool"), Value: opslevel.JsonString("true")},
+		},
+	}
+	service := opslevel.Service{ServiceId: opslevel.ServiceId{Id: "Z2lkOi8vb3BzbGV2ZWwvU2VydmljZS85NzAyMg"}, Name: "test"}
+	results := make([]opslevel.PropertyInput, 0)
+	reconciler := common.NewServiceReconciler(&common.OpslevelClient{
+		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+			return &service, nil
+		},
+		AssignPropertyHandler: func(input opslevel.PropertyInput) error {
+			results = append(results, input)
+			return nil
+		},
+	}, false, true)
+
+	// Act
+	_, err := reconciler.Reconcile(registration)
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, 1, len(results))
+	autopilot.Equals(t, "prop_bool", *results[0].Definition.Alias)
+}
+
+func Test_Reconciler_HandlePropertiesUnchanged(t *testing.T) {
+	// Arrange
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases: []string{"a_test_service_with_properties"},
+		Properties: []opslevel.PropertyInput{
+			{Definition: *opslevel.NewIdentifier("prop_object"), Value: opslevel.JsonString(`{"condition":true,"message":"hello world"}`)},
+			{Definition: *opslevel.NewIdentifier("prop_bool"), Value: opslevel.JsonString("true")},
+			{Definition: *opslevel.NewIdentifier("prop_new"), Value: opslevel.JsonString(`"new"`)},
+		},
+	}
+	service := opslevel.Service{
+		ServiceId: opslevel.ServiceId{
+			Id: opslevel.ID("Z2lkOi8vb3BzbGV2ZWwvU2VydmljZS85NzAyMg"),
+		},
+		Name: "ATestServiceWithProperties",
+	}
+	results := make([]string, 0)
+	reconciler := common.NewServiceReconciler(&common.OpslevelClient{
+		GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+			return &service, nil
+		},
+		GetPropertiesHandler: func(service *opslevel.Service) ([]opslevel.Property, error) {
+			return []opslevel.Property{
+				{
+					Definition: opslevel.PropertyDefinitionId{Id: "XXX", Aliases: []string{"prop_object"}},
+					Value:      opslevel.RefOf(opslevel.JsonString(`{"message": "hello world", "condition": true}`)),
+				},
+				{
+					Definition: opslevel.PropertyDefinitionId{Id: "YYY", Aliases: []string{"prop_bool"}},
+					Value:      opslevel.RefOf(opslevel.JsonString("false")),
+				},
+			}, nil
+		},
+		AssignPropertyHandler: func(input opslevel.PropertyInput) error {
+			results = append(results, *input.Definition.Alias)
+			return nil
+		},
+	}, false, true)
+
+	// Act
+	result, err := reconciler.Reconcile(registration)
+	autopilot.Ok(t, err)
+
+	// Assert
+	autopilot.Equals(t, []string{"prop_bool", "prop_new"}, results)
+	autopilot.Equals(t, []string{"prop_object"}, result.UnchangedProperties)
+}
+
 func newToolInputs(names ...string) []opslevel.ToolCreateInput {
 	inputs := make([]opslevel.ToolCreateInput, len(names))
 	for i, d := range names {
@@ -996,3 +1076,207 @@ func toJSON[T any](object T) string {
 	b, _ := json.Marshal(object)
 	return string(b)
 }
+
+func TestReconcilerMatchesRepositoryByAlias(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		match           common.RepositoryMatch
+		links           []opslevel.ServiceRepository
+		expectedUpdates []opslevel.ServiceRepositoryUpdateInput
+		expectedCreates int
+	}
+	serviceId := opslevel.ServiceId{Id: "Z2lkOi8vb3BzbGV2ZWwvU2VydmljZS8xNzg5Nw", Aliases: []string{"test"}}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Aliases: []string{"test"},
+		Repositories: []opslevel.ServiceRepositoryCreateInput{
+			{BaseDirectory: opslevel.RefOf("/services/new"), Repository: *opslevel.NewIdentifier("github.com:acme/monorepo")},
+		},
+	}
+	oldLink := opslevel.ServiceRepository{Id: "link-old", BaseDirectory: "/services/old", Service: serviceId}
+	otherLink := opslevel.ServiceRepository{Id: "link-other", BaseDirectory: "/services/other", Service: serviceId}
+	cases := map[string]TestCase{
+		"Base Directory Creates A Second Link": {
+			match:           common.RepositoryMatchBaseDirectory,
+			links:           []opslevel.ServiceRepository{oldLink},
+			expectedUpdates: []opslevel.ServiceRepositoryUpdateInput{},
+			expectedCreates: 1,
+		},
+		"Repository Moves The Only Link": {
+			match: common.RepositoryMatchRepository,
+			links: []opslevel.ServiceRepository{oldLink},
+			expectedUpdates: []opslevel.ServiceRepositoryUpdateInput{
+				{Id: "link-old", BaseDirectory: opslevel.RefOf("/services/new")},
+			},
+		},
+		"Repository With Multiple Links Creates A Link": {
+			match:           common.RepositoryMatchRepository,
+			links:           []opslevel.ServiceRepository{oldLink, otherLink},
+			expectedUpdates: []opslevel.ServiceRepositoryUpdateInput{},
+			expectedCreates: 1,
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		updates, creates, deletes := []opslevel.ServiceRepositoryUpdateInput{}, 0, 0
+		service := &opslevel.Service{
+			ServiceId: serviceId,
+			Name:      "Test Service",
+			Repositories: &opslevel.ServiceRepositoryConnection{Edges: []opslevel.ServiceRepositoryEdge{{
+				Node:                opslevel.RepositoryId{Id: "repo", DefaultAlias: "github.com:acme/monorepo"},
+				ServiceRepositories: test.links,
+			}}},
+		}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return service, nil
+			},
+			GetRepositoryWithAliasHandler: func(alias string) (*opslevel.Repository, error) {
+				return &opslevel.Repository{Id: "repo", Services: &opslevel.RepositoryServiceConnection{Edges: []opslevel.RepositoryServiceEdge{{
+					Node:                serviceId,
+					ServiceRepositories: test.links,
+				}}}}, nil
+			},
+			CreateServiceRepositoryHandler: func(input opslevel.ServiceRepositoryCreateInput) error {
+				creates++
+				return nil
+			},
+			UpdateServiceRepositoryHandler: func(input opslevel.ServiceRepositoryUpdateInput) error {
+				updates = append(updates, input)
+				return nil
+			},
+			DeleteServiceRepositoryHandler: func(id opslevel.ID) error {
+				deletes++
+				return nil
+			},
+		}
+		reconciler := common.NewServiceReconciler(client, false, false).
+			WithRepositoryMatch(test.match).
+			WithManagedRepositories(common.ManagedRepositories{Prefixes: []string{"github.com:acme/"}})
+		_, err := reconciler.Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedUpdates, updates)
+		autopilot.Equals(t, test.expectedCreates, creates)
+		// the moved link is kept, links left over by creating a new one are pruned
+		autopilot.Equals(t, len(test.links)-len(test.expectedUpdates), deletes)
+	})
+}
+
+func TestReconcilerEmptyValues(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		emptyValues common.EmptyValuesConfig
+		expected    []opslevel.ServiceUpdateInput
+	}
+	serviceId := opslevel.ServiceId{Id: "Z2lkOi8vb3BzbGV2ZWwvU2VydmljZS8xNzg5Nw", Aliases: []string{"test"}}
+	registration := opslevel_jq_parser.ServiceRegistration{Name: "Test Service", Aliases: []string{"test"}}
+	cases := map[string]TestCase{
+		"Ignore Keeps Every Field": {
+			emptyValues: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyIgnore},
+			expected:    []opslevel.ServiceUpdateInput{},
+		},
+		"Authoritative Field Is Unset": {
+			emptyValues: common.EmptyValuesConfig{Fields: map[string]common.EmptyValuePolicy{"description": common.EmptyValuePolicyAuthoritative}},
+			expected: []opslevel.ServiceUpdateInput{
+				{Id: &serviceId.Id, Description: opslevel.RefOf("")},
+			},
+		},
+		"Authoritative Policy Unsets Every Field That Is Set": {
+			emptyValues: common.EmptyValuesConfig{Policy: common.EmptyValuePolicyAuthoritative, Fields: map[string]common.EmptyValuePolicy{"tier": common.EmptyValuePolicyIgnore}},
+			expected: []opslevel.ServiceUpdateInput{
+				{Id: &serviceId.Id, Description: opslevel.RefOf(""), LifecycleAlias: opslevel.RefOf(""), Product: opslevel.RefOf("")},
+			},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		updates := []opslevel.ServiceUpdateInput{}
+		service := &opslevel.Service{
+			ServiceId:   serviceId,
+			Name:        "Test Service",
+			Description: "from an annotation that was removed",
+			Product:     "checkout",
+			Lifecycle:   opslevel.Lifecycle{Alias: "beta"},
+			Tier:        opslevel.Tier{Alias: "tier_1"},
+		}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return service, nil
+			},
+			UpdateServiceHandler: func(input opslevel.ServiceUpdateInput) (*opslevel.Service, error) {
+				updates = append(updates, input)
+				return service, nil
+			},
+		}
+		_, err := common.NewServiceReconciler(client, false, false).WithEmptyValues(test.emptyValues).Reconcile(registration)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expected, updates)
+	})
+}
+
+func TestReconcilerManagedFields(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		existing        *opslevel.Service
+		expectedCreates []opslevel.ServiceCreateInput
+		expectedUpdates []opslevel.ServiceUpdateInput
+	}
+	serviceId := opslevel.ServiceId{Id: "Z2lkOi8vb3BzbGV2ZWwvU2VydmljZS8xNzg5Nw", Aliases: []string{"test"}}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Name:        "Test Service",
+		Aliases:     []string{"test"},
+		Description: "from kubernetes",
+		Product:     "checkout",
+		Language:    "go",
+	}
+	manage := common.ManageConfig{
+		Policy: common.ManagePolicyAlways,
+		Fields: map[string]common.ManagePolicy{"description": common.ManagePolicyCreateOnly, "product": common.ManagePolicyNever},
+	}
+	cases := map[string]TestCase{
+		"Create Sets Create Only Fields": {
+			expectedCreates: []opslevel.ServiceCreateInput{
+				{Name: "Test Service", Description: opslevel.RefOf("from kubernetes"), Product: opslevel.RefOf(""), Language: opslevel.RefOf("go"), Framework: opslevel.RefOf("")},
+			},
+			expectedUpdates: []opslevel.ServiceUpdateInput{},
+		},
+		"Update Only Sets Always Fields": {
+			existing: &opslevel.Service{
+				ServiceId:   serviceId,
+				Name:        "Test Service",
+				Description: "edited in the UI",
+				Product:     "edited in the UI",
+				Language:    "java",
+			},
+			expectedCreates: []opslevel.ServiceCreateInput{},
+			expectedUpdates: []opslevel.ServiceUpdateInput{
+				{Id: &serviceId.Id, Language: opslevel.RefOf("go")},
+			},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		creates, updates := []opslevel.ServiceCreateInput{}, []opslevel.ServiceUpdateInput{}
+		client := &common.OpslevelClient{
+			GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+				return test.existing, nil
+			},
+			CreateServiceHandler: func(input opslevel.ServiceCreateInput) (*opslevel.Service, error) {
+				creates = append(creates, input)
+				return &opslevel.Service{ServiceId: serviceId, Name: input.Name}, nil
+			},
+			UpdateServiceHandler: func(input opslevel.ServiceUpdateInput) (*opslevel.Service, error) {
+				updates = append(updates, input)
+				return test.existing, nil
+			},
+		}
+		reconciler := common.NewServiceReconciler(client, false, false).
+			WithEmptyValues(common.EmptyValuesConfig{Policy: common.EmptyValuePolicyAuthoritative})
+		_, err := reconciler.ReconcileManaged(registration, manage)
+		// Assert
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expectedCreates, creates)
+		autopilot.Equals(t, test.expectedUpdates, updates)
+	})
+}
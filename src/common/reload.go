@@ -0,0 +1,218 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// ImportRunner runs something for every import, like its kubernetes controller, and on Update only
+// stops the ones whose import was removed or changed and starts the ones that were added or changed.
+type ImportRunner struct {
+	ctx      context.Context
+	run      func(ctx context.Context, key string, config Import) error // blocks until the import is started
+	ready    func(bool)                                                 // optional, called with true once every import started
+	mutex    sync.Mutex
+	running  map[string]context.CancelFunc
+	starting map[string]bool
+	failed   map[string]bool
+}
+
+// NewImportRunner returns a runner which calls run for every import with a context that is cancelled once the import is
+// removed or changed and the key identifying the import in every runner.  run must return once the import is started.
+func NewImportRunner(ctx context.Context, run func(ctx context.Context, key string, config Import) error) *ImportRunner {
+	return &ImportRunner{
+		ctx:      ctx,
+		run:      run,
+		running:  map[string]context.CancelFunc{},
+		starting: map[string]bool{},
+		failed:   map[string]bool{},
+	}
+}
+
+// importKeys identifies every import by its content, identical imports are told apart by their occurrence
+func importKeys(imports []Import) []string {
+	keys := make([]string, len(imports))
+	seen := map[string]int{}
+	for i, importConfig := range imports {
+		data, _ := json.Marshal(importConfig)
+		seen[string(data)]++
+		keys[i] = fmt.Sprintf("%s#%d", data, seen[string(data)])
+	}
+	return keys
+}
+
+// Update makes the runner match the imports and returns the number of imports stopped and started
+func (r *ImportRunner) Update(imports []Import) (stopped int, started int) {
+	keys := importKeys(imports)
+	wanted := map[string]bool{}
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	r.mutex.Lock()
+	for key, cancel := range r.running {
+		if !wanted[key] {
+			cancel()
+			delete(r.running, key)
+			delete(r.starting, key)
+			delete(r.failed, key)
+			stopped++
+		}
+	}
+	var start []int
+	for i, key := range keys {
+		if _, ok := r.running[key]; !ok {
+			ctx, cancel := context.WithCancel(r.ctx)
+			r.running[key] = cancel
+			r.starting[key] = true
+			start = append(start, i)
+			go r.start(ctx, key, imports[i])
+		}
+	}
+	r.mutex.Unlock()
+	if len(start) == 0 {
+		r.notify()
+	}
+	return stopped, len(start)
+}
+
+func (r *ImportRunner) start(ctx context.Context, key string, config Import) {
+	err := r.run(ctx, key, config)
+	r.mutex.Lock()
+	if _, ok := r.running[key]; ok && ctx.Err() == nil {
+		delete(r.starting, key)
+		if err != nil {
+			r.failed[key] = true
+		}
+	}
+	r.mutex.Unlock()
+	r.notify()
+}
+
+func (r *ImportRunner) notify() {
+	if r.ready == nil {
+		return
+	}
+	r.mutex.Lock()
+	ready := len(r.starting) == 0 && len(r.failed) == 0
+	r.mutex.Unlock()
+	r.ready(ready)
+}
+
+// NewControllerRunner returns a runner which starts a kubernetes controller for every import that sends the parsed
+// registrations into the queue and keeps the index up to date with the resources of the import.  The informers of a
+// controller are shut down and its registrations removed from the index once its import is stopped.  The registrations
+// of deleted resources are handed to the deletion handlers.  The runner is a producer of the queue until the context is
+// done and the informers of every import stopped.
+func NewControllerRunner(ctx context.Context, producers *QueueProducers, index *RegistrationIndex, deletions *DeletionHandlers, resync time.Duration) *ImportRunner {
+	var (
+		running     = &producerGroup{}
+		controllers atomic.Int64
+	)
+	runner := NewImportRunner(ctx, func(ctx context.Context, key string, config Import) error {
+		if !running.Add() {
+			return ctx.Err()
+		}
+		controller, err := NewImportController(config, resync)
+		if err != nil {
+			running.Done()
+			log.Error().Err(err).Msg("failed to create k8s controller")
+			return err
+		}
+		prefix := fmt.Sprintf("%d:", controllers.Add(1))
+		callback := NewIndexedParserHandler(ctx, config, producers.queue, index, prefix)
+		controller.OnAdd = callback
+		controller.OnUpdate = callback
+		controller.OnDeselect = func(obj interface{}) {
+			if resource, err := meta.Accessor(obj); err == nil {
+				index.Delete(registrationSource(prefix, resource.GetNamespace(), resource.GetName()))
+			}
+		}
+		controller.OnDelete = func(obj interface{}) {
+			resource, err := meta.Accessor(obj)
+			if err != nil {
+				return
+			}
+			// resources which were deselected before they were deleted are no longer in the index
+			if registration, ok := index.Delete(registrationSource(prefix, resource.GetNamespace(), resource.GetName())); ok {
+				deletions.Deleted(key, resource.GetNamespace()+"/"+resource.GetName(), registration.ServiceRegistration)
+			}
+		}
+		stopped, err := controller.Start(ctx)
+		go func() {
+			if stopped != nil {
+				<-stopped
+			}
+			index.DeletePrefix(prefix)
+			running.Done()
+		}()
+		return err
+	})
+	runner.ready = informersSynced.Store
+	if producers.Add() {
+		go func() {
+			<-ctx.Done()
+			running.closeAndWait()
+			producers.Done()
+		}()
+	}
+	return runner
+}
+
+// WatchConfigFiles calls onChange with the content of the config files every time any of them changed until the context
+// is done.  The directories of the files are watched so the symlink swap of a mounted ConfigMap is noticed as well.
+func WatchConfigFiles(ctx context.Context, paths []string, onChange func(files []ConfigFile)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		dir := path
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			dir = filepath.Dir(path)
+		}
+		if err = watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	last, _ := ReadConfigFiles(paths)
+	go func() {
+		defer watcher.Close()
+		// events come in bursts while a file is written, only read it once they settled
+		debounce := time.NewTimer(time.Second)
+		debounce.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-watcher.Errors:
+				log.Warn().Err(err).Msgf("error watching config files %q", paths)
+			case <-watcher.Events:
+				debounce.Reset(time.Second)
+			case <-debounce.C:
+				files, err := ReadConfigFiles(paths)
+				if err != nil {
+					log.Warn().Err(err).Msgf("failed to read changed config files %q", paths)
+					continue
+				}
+				if reflect.DeepEqual(files, last) {
+					continue
+				}
+				last = files
+				onChange(files)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,120 @@
+package common_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+type importRuns struct {
+	mutex   sync.Mutex
+	started []string
+	stopped []string
+}
+
+func (r *importRuns) run(ctx context.Context, key string, config common.Import) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.started = append(r.started, config.SelectorConfig.Kind)
+	go func() {
+		<-ctx.Done()
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.stopped = append(r.stopped, config.SelectorConfig.Kind)
+	}()
+	return nil
+}
+
+func (r *importRuns) get() ([]string, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.started...), append([]string{}, r.stopped...)
+}
+
+func kindImport(kind string) common.Import {
+	var config common.Import
+	config.SelectorConfig.ApiVersion = "apps/v1"
+	config.SelectorConfig.Kind = kind
+	return config
+}
+
+func TestImportRunnerOnlyRestartsChangedImports(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := &importRuns{}
+	runner := common.NewImportRunner(ctx, runs.run)
+	runner.Update([]common.Import{kindImport("Deployment"), kindImport("StatefulSet")})
+	changed := kindImport("DaemonSet")
+	changed.SelectorConfig.Namespaces = []string{"default"}
+
+	// Act
+	stopped, started := runner.Update([]common.Import{kindImport("Deployment"), changed})
+
+	// Assert
+	autopilot.Equals(t, 1, stopped)
+	autopilot.Equals(t, 1, started)
+	deadline := time.Now().Add(time.Second)
+	for {
+		gotStarted, gotStopped := runs.get()
+		if len(gotStarted) == 3 && len(gotStopped) == 1 {
+			sort.Strings(gotStarted)
+			autopilot.Equals(t, []string{"DaemonSet", "Deployment", "StatefulSet"}, gotStarted)
+			autopilot.Equals(t, []string{"StatefulSet"}, gotStopped)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 starts and 1 stop but got %v and %v", gotStarted, gotStopped)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestImportRunnerKeepsDuplicateImports(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := &importRuns{}
+	runner := common.NewImportRunner(ctx, runs.run)
+	runner.Update([]common.Import{kindImport("Deployment")})
+
+	// Act
+	stoppedAdded, startedAdded := runner.Update([]common.Import{kindImport("Deployment"), kindImport("Deployment")})
+	stoppedRemoved, startedRemoved := runner.Update([]common.Import{kindImport("Deployment")})
+
+	// Assert
+	autopilot.Equals(t, 0, stoppedAdded)
+	autopilot.Equals(t, 1, startedAdded)
+	autopilot.Equals(t, 1, stoppedRemoved)
+	autopilot.Equals(t, 0, startedRemoved)
+}
+
+func TestWatchConfigFilesCallsOnChange(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "opslevel-k8s.yaml")
+	autopilot.Ok(t, os.WriteFile(path, []byte("version: \"1.3.0\"\n"), 0o644))
+	changes := make(chan string, 1)
+	autopilot.Ok(t, common.WatchConfigFiles(ctx, []string{path}, func(files []common.ConfigFile) {
+		changes <- string(files[0].Data)
+	}))
+
+	// Act
+	autopilot.Ok(t, os.WriteFile(path, []byte("version: \"1.3.0\"\nservice: {}\n"), 0o644))
+
+	// Assert
+	select {
+	case got := <-changes:
+		autopilot.Equals(t, "version: \"1.3.0\"\nservice: {}\n", got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the change of the config file to be noticed")
+	}
+}
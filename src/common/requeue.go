@@ -0,0 +1,108 @@
+package common
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Requeuer retries failed registrations with a per-key exponential backoff instead of waiting for the next full resync.
+// Registrations are keyed by their aliases, only the latest registration for a key is retried.
+type Requeuer struct {
+	queue       workqueue.RateLimitingInterface
+	maxAttempts int
+	mutex       sync.Mutex
+	pending     map[string]SourcedRegistration
+}
+
+func NewRequeuer(maxAttempts int, baseDelay, maxDelay time.Duration) *Requeuer {
+	return &Requeuer{
+		queue: workqueue.NewRateLimitingQueueWithConfig(
+			workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+			workqueue.RateLimitingQueueConfig{Name: "requeue"},
+		),
+		maxAttempts: maxAttempts,
+		pending:     map[string]SourcedRegistration{},
+	}
+}
+
+func registrationKey(registration opslevel_jq_parser.ServiceRegistration) string {
+	aliases := make([]string, len(registration.Aliases))
+	copy(aliases, registration.Aliases)
+	sort.Strings(aliases)
+	return strings.Join(aliases, ",")
+}
+
+// Failed schedules the registration to be retried after its backoff and returns false once it ran out of attempts
+func (r *Requeuer) Failed(registration SourcedRegistration) bool {
+	key := registrationKey(registration.ServiceRegistration)
+	if attempts := r.queue.NumRequeues(key) + 1; attempts >= r.maxAttempts {
+		r.Succeeded(registration)
+		log.Error().Msgf("[%s] Giving up on requeuing service after %d failed attempts", registration.Name, attempts)
+		return false
+	}
+	r.mutex.Lock()
+	r.pending[key] = registration
+	r.mutex.Unlock()
+	r.queue.AddRateLimited(key)
+	log.Warn().Msgf("[%s] Requeued service after %d failed attempts", registration.Name, r.queue.NumRequeues(key))
+	return true
+}
+
+// Succeeded resets the backoff of the registration and drops any pending retry
+func (r *Requeuer) Succeeded(registration SourcedRegistration) {
+	key := registrationKey(registration.ServiceRegistration)
+	r.queue.Forget(key)
+	r.mutex.Lock()
+	delete(r.pending, key)
+	r.mutex.Unlock()
+}
+
+// Pending returns the number of registrations waiting to be retried
+func (r *Requeuer) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pending)
+}
+
+// Run sends registrations back into the queue once their backoff expired until the context is cancelled.
+// The requeuer is one of the producers of the queue until it stopped.
+func (r *Requeuer) Run(ctx context.Context, producers *QueueProducers) {
+	if !producers.Add() {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		r.queue.ShutDown()
+	}()
+	go func() {
+		defer producers.Done()
+		for {
+			item, shutdown := r.queue.Get()
+			if shutdown {
+				return
+			}
+			key := item.(string)
+			r.mutex.Lock()
+			registration, ok := r.pending[key]
+			delete(r.pending, key)
+			r.mutex.Unlock()
+			r.queue.Done(item)
+			if !ok || ctx.Err() != nil {
+				continue
+			}
+			queueDepth.Inc()
+			select {
+			case <-ctx.Done():
+				queueDepth.Dec()
+			case producers.queue <- registration:
+			}
+		}
+	}()
+}
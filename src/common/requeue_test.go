@@ -0,0 +1,109 @@
+package common_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestRequeuerRequeuesFailedRegistration(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := make(chan common.SourcedRegistration, 1)
+	requeuer := common.NewRequeuer(3, time.Millisecond, 10*time.Millisecond)
+	requeuer.Run(ctx, common.NewQueueProducers(queue))
+	registration := common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "test", Aliases: []string{"b", "a"}}}
+
+	// Act
+	requeued := requeuer.Failed(registration)
+
+	// Assert
+	autopilot.Equals(t, true, requeued)
+	select {
+	case got := <-queue:
+		autopilot.Equals(t, "test", got.Name)
+	case <-time.After(time.Second):
+		t.Fatal("expected the registration to be requeued")
+	}
+	autopilot.Equals(t, 0, requeuer.Pending())
+}
+
+func TestRequeuerGivesUpAfterMaxAttempts(t *testing.T) {
+	// Arrange
+	requeuer := common.NewRequeuer(3, time.Hour, time.Hour)
+	registration := common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "test", Aliases: []string{"a"}}}
+
+	// Act
+	results := []bool{requeuer.Failed(registration), requeuer.Failed(registration), requeuer.Failed(registration)}
+
+	// Assert
+	autopilot.Equals(t, []bool{true, true, false}, results)
+	autopilot.Equals(t, 0, requeuer.Pending())
+}
+
+func TestRequeuerSucceededDropsPendingRetry(t *testing.T) {
+	// Arrange
+	requeuer := common.NewRequeuer(3, time.Hour, time.Hour)
+	registration := common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "test", Aliases: []string{"a"}}}
+	requeuer.Failed(registration)
+
+	// Act
+	requeuer.Succeeded(registration)
+
+	// Assert
+	autopilot.Equals(t, 0, requeuer.Pending())
+	autopilot.Equals(t, true, requeuer.Failed(registration))
+	autopilot.Equals(t, true, requeuer.Failed(registration))
+}
+
+func TestRequeuerClosesQueueOnlyAfterItStopped(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := make(chan common.SourcedRegistration)
+	producers := common.NewQueueProducers(queue)
+	producers.CloseWhenDone(ctx)
+	requeuer := common.NewRequeuer(3, time.Millisecond, time.Millisecond)
+	requeuer.Run(ctx, producers)
+	registration := common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "test", Aliases: []string{"test"}}}
+	requeuer.Failed(registration)
+	time.Sleep(10 * time.Millisecond)
+
+	// Act
+	cancel()
+	_, open := <-queue
+	for open {
+		_, open = <-queue
+	}
+
+	// Assert
+	autopilot.Equals(t, false, producers.Add())
+}
+
+func TestQueueProducersCloseQueueAfterEveryProducer(t *testing.T) {
+	// Arrange
+	queue := make(chan common.SourcedRegistration)
+	producers := common.NewQueueProducers(queue)
+	autopilot.Equals(t, true, producers.Add())
+	autopilot.Equals(t, true, producers.Add())
+	registration := common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "test"}}
+
+	// Act
+	producers.Close()
+	go func() {
+		defer producers.Done()
+		queue <- registration
+	}()
+	producers.Done()
+	got := <-queue
+	_, open := <-queue
+
+	// Assert
+	autopilot.Equals(t, "test", got.Name)
+	autopilot.Equals(t, false, open)
+	autopilot.Equals(t, false, producers.Add())
+}
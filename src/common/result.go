@@ -0,0 +1,198 @@
+package common
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type ChangeAction string
+
+const (
+	ChangeActionCreateService           ChangeAction = "create-service"
+	ChangeActionUpdateService           ChangeAction = "update-service"
+	ChangeActionDeleteService           ChangeAction = "delete-service"
+	ChangeActionCreateAlias             ChangeAction = "create-alias"
+	ChangeActionDeleteAlias             ChangeAction = "delete-alias"
+	ChangeActionAssignTags              ChangeAction = "assign-tags"
+	ChangeActionCreateTag               ChangeAction = "create-tag"
+	ChangeActionDeleteTag               ChangeAction = "delete-tag"
+	ChangeActionCreateTool              ChangeAction = "create-tool"
+	ChangeActionUpdateTool              ChangeAction = "update-tool"
+	ChangeActionDeleteTool              ChangeAction = "delete-tool"
+	ChangeActionCreateServiceRepository ChangeAction = "create-service-repository"
+	ChangeActionUpdateServiceRepository ChangeAction = "update-service-repository"
+	ChangeActionDeleteServiceRepository ChangeAction = "delete-service-repository"
+	ChangeActionAssignProperty          ChangeAction = "assign-property"
+	ChangeActionLookupRepository        ChangeAction = "lookup-repository"
+)
+
+type ReconcileAction string
+
+const (
+	ReconcileActionCreated   ReconcileAction = "created"
+	ReconcileActionUpdated   ReconcileAction = "updated"
+	ReconcileActionUnchanged ReconcileAction = "unchanged"
+	ReconcileActionSkipped   ReconcileAction = "skipped"
+	ReconcileActionFailed    ReconcileAction = "failed"
+)
+
+// Operation is a single API call made while reconciling a service
+type Operation struct {
+	Action ChangeAction `json:"action"`
+	Target string       `json:"target"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// ReconcileResult describes everything that happened while reconciling a single registration
+type ReconcileResult struct {
+	Service             string          `json:"service"`
+	Aliases             []string        `json:"aliases"`
+	Action              ReconcileAction `json:"action"`
+	Operations          []Operation     `json:"operations,omitempty"`
+	UnchangedProperties []string        `json:"unchangedProperties,omitempty"` // definitions of properties which already had the desired value
+	Diff                string          `json:"diff,omitempty"`
+	Error               string          `json:"error,omitempty"`
+}
+
+// Record adds the outcome of an API call to the result
+func (r *ReconcileResult) Record(action ChangeAction, target string, err error) {
+	operation := Operation{Action: action, Target: target}
+	if err != nil {
+		operation.Error = err.Error()
+	}
+	r.Operations = append(r.Operations, operation)
+}
+
+// Failed is true if the reconciliation or any of its operations failed
+func (r *ReconcileResult) Failed() bool {
+	if r.Error != "" {
+		return true
+	}
+	for _, operation := range r.Operations {
+		if operation.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconcileReport collects the results of a reconciliation run and is safe for concurrent use
+type ReconcileReport struct {
+	mutex     sync.Mutex
+	Results   []*ReconcileResult     `json:"results"`
+	Conflicts []RegistrationConflict `json:"conflicts,omitempty"`
+}
+
+func NewReconcileReport() *ReconcileReport {
+	return &ReconcileReport{Results: []*ReconcileResult{}}
+}
+
+func (r *ReconcileReport) Add(result *ReconcileResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Results = append(r.Results, result)
+}
+
+// AddConflicts records the conflicts found while coalescing the registrations
+func (r *ReconcileReport) AddConflicts(conflicts ...RegistrationConflict) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Conflicts = append(r.Conflicts, conflicts...)
+}
+
+// Failures returns the number of results that failed
+func (r *ReconcileReport) Failures() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	count := 0
+	for _, result := range r.Results {
+		if result.Failed() {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary returns the number of results for each action
+func (r *ReconcileReport) Summary() map[ReconcileAction]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	summary := map[ReconcileAction]int{}
+	for _, result := range r.Results {
+		summary[result.Action]++
+	}
+	return summary
+}
+
+func (r *ReconcileReport) sorted() []*ReconcileResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	results := make([]*ReconcileResult, len(r.Results))
+	copy(results, r.Results)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Service < results[j].Service
+	})
+	return results
+}
+
+func (r *ReconcileReport) JSON() ([]byte, error) {
+	r.mutex.Lock()
+	conflicts := r.Conflicts
+	r.mutex.Unlock()
+	return json.MarshalIndent(struct {
+		Summary   map[ReconcileAction]int `json:"summary"`
+		Results   []*ReconcileResult      `json:"results"`
+		Conflicts []RegistrationConflict  `json:"conflicts,omitempty"`
+	}{r.Summary(), r.sorted(), conflicts}, "", "    ")
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+// JUnit renders the report as a JUnit xml test suite with a test case per service
+func (r *ReconcileReport) JUnit() ([]byte, error) {
+	suite := junitTestSuite{Name: "kubectl-opslevel"}
+	for _, result := range r.sorted() {
+		testCase := junitTestCase{Name: result.Service, Classname: string(result.Action)}
+		if result.Failed() {
+			var reasons []string
+			if result.Error != "" {
+				reasons = append(reasons, result.Error)
+			}
+			for _, operation := range result.Operations {
+				if operation.Error != "" {
+					reasons = append(reasons, fmt.Sprintf("%s '%s': %s", operation.Action, operation.Target, operation.Error))
+				}
+			}
+			testCase.Failure = &junitFailure{Message: fmt.Sprintf("%d failures", len(reasons)), Text: strings.Join(reasons, "\n")}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Tests = len(suite.TestCases)
+	output, err := xml.MarshalIndent(suite, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
@@ -0,0 +1,106 @@
+package common_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestReconcileResult(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		client           *common.OpslevelClient
+		disableCreate    bool
+		expectedAction   common.ReconcileAction
+		expectedFailed   bool
+		expectedOpsCount int
+	}
+	service := opslevel.Service{
+		ServiceId: opslevel.ServiceId{Id: opslevel.ID("XXX"), Aliases: []string{"test"}},
+		Name:      "test",
+		Tags:      &opslevel.TagConnection{},
+		Tools:     &opslevel.ToolConnection{},
+	}
+	registration := opslevel_jq_parser.ServiceRegistration{
+		Name:    "test",
+		Aliases: []string{"test", "k8s:test"},
+	}
+	cases := map[string]TestCase{
+		"Created": {
+			client: &common.OpslevelClient{
+				GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+					return &opslevel.Service{}, nil
+				},
+				CreateServiceHandler: func(input opslevel.ServiceCreateInput) (*opslevel.Service, error) {
+					return &service, nil
+				},
+			},
+			expectedAction:   common.ReconcileActionCreated,
+			expectedOpsCount: 2,
+		},
+		"Skipped When Creation Disabled": {
+			client: &common.OpslevelClient{
+				GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+					return &opslevel.Service{}, nil
+				},
+			},
+			disableCreate:  true,
+			expectedAction: common.ReconcileActionSkipped,
+		},
+		"Updated With Failed Alias": {
+			client: &common.OpslevelClient{
+				GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+					return &service, nil
+				},
+				CreateAliasHandler: func(input opslevel.AliasCreateInput) error {
+					return fmt.Errorf("api error")
+				},
+			},
+			expectedAction:   common.ReconcileActionUpdated,
+			expectedFailed:   true,
+			expectedOpsCount: 1,
+		},
+		"Failed Lookup": {
+			client: &common.OpslevelClient{
+				GetServiceHandler: func(alias string) (*opslevel.Service, error) {
+					return nil, fmt.Errorf("api error")
+				},
+			},
+			expectedAction: common.ReconcileActionFailed,
+			expectedFailed: true,
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		result, _ := common.NewServiceReconciler(test.client, test.disableCreate, false).Reconcile(registration)
+		// Assert
+		autopilot.Equals(t, test.expectedAction, result.Action)
+		autopilot.Equals(t, test.expectedFailed, result.Failed())
+		autopilot.Equals(t, test.expectedOpsCount, len(result.Operations))
+	})
+}
+
+func TestReconcileReport(t *testing.T) {
+	// Arrange
+	report := common.NewReconcileReport()
+	ok := &common.ReconcileResult{Service: "a", Action: common.ReconcileActionCreated}
+	failed := &common.ReconcileResult{Service: "b", Action: common.ReconcileActionUpdated}
+	failed.Record(common.ChangeActionCreateTag, "env = prod", fmt.Errorf("api error"))
+
+	// Act
+	report.Add(failed)
+	report.Add(ok)
+	junit, err := report.JUnit()
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, 1, report.Failures())
+	autopilot.Equals(t, 1, report.Summary()[common.ReconcileActionCreated])
+	autopilot.Assert(t, strings.Contains(string(junit), `<testsuite name="kubectl-opslevel" tests="2" failures="1">`), "expected junit testsuite totals")
+	autopilot.Assert(t, strings.Contains(string(junit), "create-tag &#39;env = prod&#39;: api error"), "expected junit failure reason")
+}
@@ -0,0 +1,16 @@
+package common
+
+// NewCreateOnceOpslevelClient returns a client which makes every call through client except the creates,
+// which are made through once.  The http transport of client retries connection failures, 429 and 5xx responses,
+// honouring their Retry-After header, which is safe to repeat for queries, updates and deletes.  A create is not
+// idempotent and may have been applied when its response was lost so once should be a client whose transport does
+// not retry, a failed create is left to the next reconcile which looks the service up again before creating.
+func NewCreateOnceOpslevelClient(client *OpslevelClient, once *OpslevelClient) *OpslevelClient {
+	created := *client
+	created.CreateServiceHandler = once.CreateServiceHandler
+	created.CreateAliasHandler = once.CreateAliasHandler
+	created.CreateTagHandler = once.CreateTagHandler
+	created.CreateToolHandler = once.CreateToolHandler
+	created.CreateServiceRepositoryHandler = once.CreateServiceRepositoryHandler
+	return &created
+}
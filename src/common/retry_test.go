@@ -0,0 +1,117 @@
+package common_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/opslevel/opslevel-go/v2024"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+// newCreateOnceServer returns a client against the server whose calls are attempted twice and whose creates once
+func newCreateOnceServer(t *testing.T, handler http.HandlerFunc) *common.OpslevelClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := func(retries int) *common.OpslevelClient {
+		return common.NewOpslevelClient(opslevel.NewGQLClient(
+			opslevel.SetAPIToken("test"),
+			opslevel.SetURL(server.URL),
+			opslevel.SetMaxRetries(retries),
+		))
+	}
+	return common.NewCreateOnceOpslevelClient(client(1), client(0))
+}
+
+func tooManyRequests(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "0")
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+func TestCreateOnceOpslevelClientAgainstServer(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		respond                func(w http.ResponseWriter)
+		expectedCreateAttempts int32
+		expectedDeleteAttempts int32
+	}
+	cases := map[string]TestCase{
+		"Too Many Requests": {
+			respond:                tooManyRequests,
+			expectedCreateAttempts: 1,
+			expectedDeleteAttempts: 2,
+		},
+		"Connection Lost": {
+			respond: func(w http.ResponseWriter) {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			},
+			expectedCreateAttempts: 1,
+			expectedDeleteAttempts: 2,
+		},
+		"Bad Request": {
+			respond:                func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadRequest) },
+			expectedCreateAttempts: 1,
+			expectedDeleteAttempts: 1,
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		var attempts atomic.Int32
+		client := newCreateOnceServer(t, func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			test.respond(w)
+		})
+
+		createErr := client.CreateTag(opslevel.TagCreateInput{Key: "env", Value: "test"})
+		createAttempts := attempts.Swap(0)
+		deleteErr := client.DeleteTag(opslevel.ID("XXX"))
+
+		// Assert
+		autopilot.Assert(t, createErr != nil && deleteErr != nil, "expected both calls to fail")
+		autopilot.Equals(t, test.expectedCreateAttempts, createAttempts)
+		autopilot.Equals(t, test.expectedDeleteAttempts, attempts.Load())
+	})
+}
+
+func TestCreateOnceOpslevelClientHonoursRetryAfter(t *testing.T) {
+	// Arrange
+	var attempts atomic.Int32
+	client := newCreateOnceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			tooManyRequests(w)
+			return
+		}
+		w.Write([]byte(`{"data":{"tagDelete":{"errors":[]}}}`))
+	})
+	start := time.Now()
+
+	// Act
+	err := client.DeleteTag(opslevel.ID("XXX"))
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, int32(2), attempts.Load())
+	autopilot.Assert(t, time.Since(start) < time.Second, "expected the retry to wait for Retry-After instead of the backoff")
+}
+
+func TestRetryExhaustedLookupIsSurfacedPerService(t *testing.T) {
+	// Arrange
+	client := newCreateOnceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		tooManyRequests(w)
+	})
+	registration := opslevel_jq_parser.ServiceRegistration{Name: "test", Aliases: []string{"test"}}
+
+	// Act
+	result, err := common.NewServiceReconciler(client, false, false).Reconcile(registration)
+
+	// Assert
+	autopilot.Assert(t, err != nil, "expected an error")
+	autopilot.Equals(t, common.ReconcileActionFailed, result.Action)
+	autopilot.Assert(t, strings.Contains(result.Error, "giving up after 2 attempt(s)"), "expected the exhausted retries in the result")
+}
@@ -4,13 +4,15 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
-	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
 	"github.com/rs/zerolog/log"
 )
 
-func InitSignalHandler(parent context.Context, queue chan<- opslevel_jq_parser.ServiceRegistration) context.Context {
+// InitSignalHandler returns a context which is cancelled on SIGINT or SIGTERM.  The queue is not closed here,
+// its QueueProducers close it once every producer stopped sending.
+func InitSignalHandler(parent context.Context) context.Context {
 	ctx, cancel := context.WithCancel(parent)
 	closeChannel := make(chan os.Signal, 1)
 	signal.Notify(closeChannel, syscall.SIGINT, syscall.SIGTERM)
@@ -18,7 +20,69 @@ func InitSignalHandler(parent context.Context, queue chan<- opslevel_jq_parser.S
 		sig := <-closeChannel
 		log.Info().Str("signal", sig.String()).Msg("Handling interruption")
 		cancel()
-		close(queue)
 	}()
 	return ctx
 }
+
+// producerGroup is a sync.WaitGroup which refuses new members once it is closing, so waiting for it cannot race with Add
+type producerGroup struct {
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+	closing bool
+}
+
+// Add registers a member which has to call Done once it stopped.  It returns false without registering once the group
+// is closing.
+func (g *producerGroup) Add() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closing {
+		return false
+	}
+	g.wg.Add(1)
+	return true
+}
+
+func (g *producerGroup) Done() {
+	g.wg.Done()
+}
+
+func (g *producerGroup) refuse() {
+	g.mutex.Lock()
+	g.closing = true
+	g.mutex.Unlock()
+}
+
+// closeAndWait refuses new members and blocks until every registered member is done
+func (g *producerGroup) closeAndWait() {
+	g.refuse()
+	g.wg.Wait()
+}
+
+// QueueProducers owns the queue and closes it once, after every goroutine which sends into it stopped.  A producer
+// registers with Add before it sends, a producer added once the queue is closing must not send.
+type QueueProducers struct {
+	producerGroup
+	queue chan<- SourcedRegistration
+}
+
+func NewQueueProducers(queue chan<- SourcedRegistration) *QueueProducers {
+	return &QueueProducers{queue: queue}
+}
+
+// Close refuses new producers and closes the queue once every registered producer is done
+func (p *QueueProducers) Close() {
+	p.refuse()
+	go func() {
+		p.wg.Wait()
+		close(p.queue)
+	}()
+}
+
+// CloseWhenDone closes the queue like Close once the context is done
+func (p *QueueProducers) CloseWhenDone(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		p.Close()
+	}()
+}
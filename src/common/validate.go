@@ -0,0 +1,198 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+	libjq_go "github.com/flant/libjq-go"
+	validator "github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSchema returns the jsonschema of the configuration file.  Only fields tagged 'jsonschema:"required"' are required.
+func ConfigSchema() *jsonschema.Schema {
+	reflector := jsonschema.Reflector{RequiredFromJSONSchemaTags: true}
+	return reflector.Reflect(&Config{})
+}
+
+// ConfigError is a problem found at a field of the configuration file
+type ConfigError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("%d: %s: %s", e.Line, e.Field, e.Message)
+}
+
+// configNode is a yaml node of the configuration file with its dotted field path and json pointer
+type configNode struct {
+	node    *yaml.Node
+	line    int
+	field   string
+	pointer string
+}
+
+// walkConfig calls visit for every node below the root with the line of its key for mapping values
+func walkConfig(root configNode, visit func(configNode)) {
+	visit(root)
+	switch root.node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range root.node.Content {
+			walkConfig(configNode{node: child, line: child.Line}, visit)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.node.Content); i += 2 {
+			key, value := root.node.Content[i], root.node.Content[i+1]
+			field := key.Value
+			if root.field != "" {
+				field = root.field + "." + key.Value
+			}
+			walkConfig(configNode{node: value, line: key.Line, field: field, pointer: root.pointer + "/" + escapePointer(key.Value)}, visit)
+		}
+	case yaml.SequenceNode:
+		for i, value := range root.node.Content {
+			walkConfig(configNode{node: value, line: value.Line, field: fmt.Sprintf("%s[%d]", root.field, i), pointer: root.pointer + "/" + strconv.Itoa(i)}, visit)
+		}
+	}
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// isJQExpression is true for the fields of a configuration which are jq expressions,
+// every value below an import's 'opslevel' and the selector's 'excludes'
+func isJQExpression(pointer string) bool {
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	if len(parts) < 5 || parts[0] != "service" || parts[1] != "import" {
+		return false
+	}
+	return parts[3] == "opslevel" || (parts[3] == "selector" && parts[4] == "excludes" && len(parts) == 6)
+}
+
+// ValidateConfig checks the configuration file against the jsonschema and compiles every jq expression in it with
+// the variables bound.  Every problem is returned with the line and field it was found at.
+func ValidateConfig(data string, variables map[string]string) []ConfigError {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &document); err != nil {
+		return []ConfigError{{Line: yamlErrorLine(err), Message: err.Error()}}
+	}
+	if len(document.Content) == 0 {
+		return []ConfigError{{Line: 1, Message: "the config file is empty"}}
+	}
+	problems := interpolateEnvironment(&document)
+	bindings := newVariableBindings(variables)
+	lines := map[string]configNode{}
+	walkConfig(configNode{node: &document, line: 1}, func(node configNode) {
+		if node.node.Kind == yaml.DocumentNode {
+			return
+		}
+		lines[node.pointer] = node
+		if node.node.Kind == yaml.ScalarNode && node.node.Tag == "!!str" && isJQExpression(node.pointer) && node.node.Value != "" {
+			if err := compileJQ(node.node.Value, bindings); err != nil {
+				problems = append(problems, ConfigError{Line: node.node.Line, Field: node.field, Message: err.Error()})
+			}
+		}
+	})
+	problems = append(problems, validateSchema(document.Content[0], lines)...)
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+func compileJQ(expression string, bindings []variableBinding) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid jq expression '%s': %v", expression, r)
+		}
+	}()
+	if _, err = libjq_go.Jq().Program(bindVariables(expression, bindings)).Precompile(); err != nil {
+		// libjq reports the expression again over multiple lines, the first one has the reason
+		reason, _, _ := strings.Cut(err.Error(), "\n")
+		reason = strings.TrimPrefix(strings.TrimPrefix(reason, "compile: "), "jq: error: ")
+		reason = strings.TrimSuffix(strings.TrimSuffix(reason, " (Unix shell quoting issues?) at <top-level>, line 1:"), ":")
+		return fmt.Errorf("invalid jq expression '%s': %s", expression, reason)
+	}
+	return nil
+}
+
+func validateSchema(root *yaml.Node, lines map[string]configNode) []ConfigError {
+	var instance any
+	if err := root.Decode(&instance); err != nil {
+		return []ConfigError{{Line: root.Line, Message: err.Error()}}
+	}
+	// round trip through json so the schema validator only sees json types
+	encoded, err := json.Marshal(instance)
+	if err != nil {
+		return []ConfigError{{Line: root.Line, Message: err.Error()}}
+	}
+	if err = json.Unmarshal(encoded, &instance); err != nil {
+		return []ConfigError{{Line: root.Line, Message: err.Error()}}
+	}
+	schema, err := json.Marshal(ConfigSchema())
+	if err != nil {
+		return []ConfigError{{Line: root.Line, Message: err.Error()}}
+	}
+	compiled, err := validator.CompileString("config.schema.json", string(schema))
+	if err != nil {
+		return []ConfigError{{Line: root.Line, Message: err.Error()}}
+	}
+	err = compiled.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	validation, ok := err.(*validator.ValidationError)
+	if !ok {
+		return []ConfigError{{Line: root.Line, Message: err.Error()}}
+	}
+	var problems []ConfigError
+	for _, leaf := range schemaLeaves(validation) {
+		location := leaf.InstanceLocation
+		// point at the unknown field itself instead of the object containing it
+		if strings.HasSuffix(leaf.KeywordLocation, "/additionalProperties") {
+			if matches := quotedNames.FindAllStringSubmatch(leaf.Message, -1); len(matches) == 1 {
+				location += "/" + escapePointer(matches[0][1])
+			}
+		}
+		node, ok := lines[location]
+		if !ok {
+			node = configNode{line: root.Line}
+		}
+		problems = append(problems, ConfigError{Line: node.line, Field: node.field, Message: leaf.Message})
+	}
+	return problems
+}
+
+var quotedNames = regexp.MustCompile(`'([^']*)'`)
+
+// schemaLeaves returns the most specific validation errors, the ones without causes
+func schemaLeaves(err *validator.ValidationError) []*validator.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*validator.ValidationError{err}
+	}
+	var leaves []*validator.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, schemaLeaves(cause)...)
+	}
+	return leaves
+}
+
+func yamlErrorLine(err error) int {
+	var line int
+	if _, scanErr := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); scanErr == nil {
+		return line
+	}
+	return 1
+}
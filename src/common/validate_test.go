@@ -0,0 +1,90 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestValidateConfig(t *testing.T) {
+	// Arrange
+	type TestCase struct {
+		config   string
+		expected []common.ConfigError
+	}
+	cases := map[string]TestCase{
+		"Sample": {
+			config: common.ConfigSample,
+		},
+		"Simple": {
+			config: common.ConfigSimple,
+		},
+		"Invalid Yaml": {
+			config:   "version: 1\n  bad: [",
+			expected: []common.ConfigError{{Line: 2, Message: "yaml: line 2: mapping values are not allowed in this context"}},
+		},
+		"Schema And JQ Errors": {
+			config: `version: "1.3.0"
+service:
+  typo: true
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        excludes:
+          - .metadata.namespace ==
+      onDelete:
+        policy: archive
+      opslevel:
+        name: .metadata.name
+        tags:
+          assign:
+            - '{"team": .metadata.labels.team'
+`,
+			expected: []common.ConfigError{
+				{Line: 3, Field: "service.typo", Message: "additionalProperties 'typo' not allowed"},
+				{Line: 9, Field: "service.import[0].selector.excludes[0]", Message: "invalid jq expression '.metadata.namespace ==': syntax error, unexpected $end"},
+				{Line: 11, Field: "service.import[0].onDelete.policy", Message: `value must be one of "ignore", "detach", "tag", "delete"`},
+				{Line: 16, Field: "service.import[0].opslevel.tags.assign[0]", Message: `invalid jq expression '{"team": .metadata.labels.team': syntax error, unexpected $end, expecting '}'`},
+			},
+		},
+		"Missing Version": {
+			config:   "service:\n  import: []\n",
+			expected: []common.ConfigError{{Line: 1, Message: "missing properties: 'version'"}},
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		problems := common.ValidateConfig(test.config, nil)
+		// Assert
+		autopilot.Equals(t, test.expected, problems)
+	})
+}
+
+func TestValidateConfigWithVariables(t *testing.T) {
+	// Arrange
+	config := `version: "1.3.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+      opslevel:
+        name: '"\(.metadata.name)-\($cluster)"'
+        owner: '${UNSET_VALIDATE_OWNER}'
+`
+
+	// Act
+	withoutVariables := common.ValidateConfig(config, nil)
+	withVariables := common.ValidateConfig(config, map[string]string{"cluster": "prod-eu"})
+
+	// Assert
+	autopilot.Equals(t, []common.ConfigError{
+		{Line: 8, Field: "service.import[0].opslevel.name", Message: `invalid jq expression '"\(.metadata.name)-\($cluster)"': $cluster is not defined at <top-level>, line 1`},
+		{Line: 9, Message: "environment variable 'UNSET_VALIDATE_OWNER' is not set"},
+	}, withoutVariables)
+	autopilot.Equals(t, []common.ConfigError{
+		{Line: 9, Message: "environment variable 'UNSET_VALIDATE_OWNER' is not set"},
+	}, withVariables)
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// environmentReference matches '${VAR}' and the escaped '$${VAR}' which is kept as a literal '${VAR}'
+var environmentReference = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// InterpolateConfig replaces every '${VAR}' in the scalar values of the configuration document with the value of the
+// environment variable, comments and keys are left as they are.  A variable which is not set is an error, '$${VAR}' is
+// kept as a literal '${VAR}'.
+func InterpolateConfig(document *yaml.Node) error {
+	if problems := interpolateEnvironment(document); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+func interpolateEnvironment(node *yaml.Node) []ConfigError {
+	var problems []ConfigError
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			problems = append(problems, interpolateEnvironment(child)...)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			problems = append(problems, interpolateEnvironment(node.Content[i])...)
+		}
+	case yaml.ScalarNode:
+		value := environmentReference.ReplaceAllStringFunc(node.Value, func(match string) string {
+			groups := environmentReference.FindStringSubmatch(match)
+			if groups[1] != "" {
+				return match[1:]
+			}
+			value, ok := os.LookupEnv(groups[2])
+			if !ok {
+				problems = append(problems, ConfigError{Line: node.Line, Message: fmt.Sprintf("environment variable '%s' is not set", groups[2])})
+			}
+			return value
+		})
+		changed := value != node.Value
+		node.Value = value
+		if changed && node.Style == 0 {
+			// an unquoted value is typed by what it was replaced with, like a number or a boolean
+			node.Tag = ""
+			node.Tag = node.ShortTag()
+		}
+	}
+	return problems
+}
+
+// ParseVariables parses 'name=value' pairs into jq variables, names must be valid jq identifiers
+func ParseVariables(pairs []string) (map[string]string, error) {
+	variables := map[string]string{}
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("variable '%s' is not in the format 'name=value'", pair)
+		}
+		if !variableName.MatchString(name) {
+			return nil, fmt.Errorf("variable name '%s' is not a valid jq identifier", name)
+		}
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+// BindVariables makes the variables available as '$name' strings to every jq expression of the imports
+// which references them.
+func (c *Config) BindVariables(variables map[string]string) {
+	if len(variables) == 0 {
+		return
+	}
+	bindings := newVariableBindings(variables)
+	bind := func(expressions []string) {
+		for i, expression := range expressions {
+			expressions[i] = bindVariables(expression, bindings)
+		}
+	}
+	for i := range c.Service.Import {
+		selector := &c.Service.Import[i].SelectorConfig
+		mapping := &c.Service.Import[i].OpslevelConfig
+		bind(selector.Excludes)
+		for _, field := range []*string{&mapping.Description, &mapping.Framework, &mapping.Language, &mapping.Lifecycle,
+			&mapping.Name, &mapping.Owner, &mapping.Product, &mapping.System, &mapping.Tier} {
+			*field = bindVariables(*field, bindings)
+		}
+		for key, expression := range mapping.Properties {
+			mapping.Properties[key] = bindVariables(expression, bindings)
+		}
+		bind(mapping.Aliases)
+		bind(mapping.Repositories)
+		bind(mapping.Tags.Assign)
+		bind(mapping.Tags.Create)
+		bind(mapping.Tools)
+	}
+}
+
+// variableBinding is a variable as a jq binding together with the pattern of its references in an expression
+type variableBinding struct {
+	binding   string
+	reference *regexp.Regexp
+}
+
+// newVariableBindings compiles the reference of every variable once, in order of their names
+func newVariableBindings(variables map[string]string) []variableBinding {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	bindings := make([]variableBinding, 0, len(names))
+	for _, name := range names {
+		value, _ := json.Marshal(variables[name])
+		bindings = append(bindings, variableBinding{
+			binding:   fmt.Sprintf("%s as $%s | ", value, name),
+			reference: regexp.MustCompile(`\$` + regexp.QuoteMeta(name) + `\b`),
+		})
+	}
+	return bindings
+}
+
+// bindVariables prefixes the expression with a binding of every variable it references, e.g. '"prod" as $cluster | '
+func bindVariables(expression string, bindings []variableBinding) string {
+	if expression == "" {
+		return expression
+	}
+	var prefix strings.Builder
+	for _, binding := range bindings {
+		if binding.reference.MatchString(expression) {
+			prefix.WriteString(binding.binding)
+		}
+	}
+	if prefix.Len() == 0 {
+		return expression
+	}
+	return prefix.String() + expression
+}
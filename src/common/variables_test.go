@@ -0,0 +1,108 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	"github.com/rocktavious/autopilot/v2023"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolateConfig(t *testing.T) {
+	// Arrange
+	t.Setenv("CLUSTER", "prod-eu")
+	type TestCase struct {
+		config   string
+		expected string
+		err      string
+	}
+	cases := map[string]TestCase{
+		"Replaces Variable": {
+			config:   "owner: '\"${CLUSTER}\"'\n",
+			expected: "owner: '\"prod-eu\"'\n",
+		},
+		"Keeps Escaped Variable": {
+			config:   "owner: '$${CLUSTER}'\n",
+			expected: "owner: '${CLUSTER}'\n",
+		},
+		"Keeps JQ Variables": {
+			config:   "name: '$cluster'\n",
+			expected: "name: '$cluster'\n",
+		},
+		"Unset Variable": {
+			config: "version: \"1.3.0\"\nowner: ${UNSET_CLUSTER_OWNER}\n",
+			err:    "2: environment variable 'UNSET_CLUSTER_OWNER' is not set",
+		},
+		"Ignores Comments": {
+			config:   "# owner: ${UNSET_CLUSTER_OWNER}\nowner: ${CLUSTER} # or ${UNSET_CLUSTER_OWNER}\n",
+			expected: "# owner: ${UNSET_CLUSTER_OWNER}\nowner: prod-eu # or ${UNSET_CLUSTER_OWNER}\n",
+		},
+	}
+	// Act
+	autopilot.RunTableTests(t, cases, func(t *testing.T, test TestCase) {
+		var document yaml.Node
+		autopilot.Ok(t, yaml.Unmarshal([]byte(test.config), &document))
+		err := common.InterpolateConfig(&document)
+		// Assert
+		if test.err != "" {
+			autopilot.Equals(t, test.err, err.Error())
+			return
+		}
+		autopilot.Ok(t, err)
+		output, err := yaml.Marshal(&document)
+		autopilot.Ok(t, err)
+		autopilot.Equals(t, test.expected, string(output))
+	})
+}
+
+func TestParseVariables(t *testing.T) {
+	// Act
+	variables, err := common.ParseVariables([]string{"cluster=prod-eu", "query=a=b"})
+	_, invalidName := common.ParseVariables([]string{"my-cluster=prod"})
+	_, missingValue := common.ParseVariables([]string{"cluster"})
+
+	// Assert
+	autopilot.Ok(t, err)
+	autopilot.Equals(t, map[string]string{"cluster": "prod-eu", "query": "a=b"}, variables)
+	autopilot.Equals(t, "variable name 'my-cluster' is not a valid jq identifier", invalidName.Error())
+	autopilot.Equals(t, "variable 'cluster' is not in the format 'name=value'", missingValue.Error())
+}
+
+func TestBindVariables(t *testing.T) {
+	// Arrange
+	config, err := common.ParseConfig(`version: "1.3.0"
+service:
+  import:
+    - selector:
+        apiVersion: apps/v1
+        kind: Deployment
+        excludes:
+          - .metadata.namespace == $cluster
+      opslevel:
+        name: '"\(.metadata.name)-\($cluster)"'
+        owner: .metadata.namespace
+        tags:
+          assign:
+            - '{"cluster": $cluster, "region": $region}'
+`)
+	autopilot.Ok(t, err)
+	resource := map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "web", "namespace": "default"},
+	}
+
+	// Act
+	config.BindVariables(map[string]string{"cluster": "prod-eu", "region": "eu"})
+	results := common.EvaluateConfig(config, resource, false)
+
+	// Assert
+	mapping := config.Service.Import[0].OpslevelConfig
+	autopilot.Equals(t, ".metadata.namespace", mapping.Owner)
+	autopilot.Equals(t, `"prod-eu" as $cluster | "eu" as $region | {"cluster": $cluster, "region": $region}`, mapping.Tags.Assign[0])
+	autopilot.Equals(t, 1, len(results))
+	autopilot.Equals(t, "", results[0].Error)
+	autopilot.Equals(t, false, results[0].Excluded)
+	autopilot.Equals(t, "web-prod-eu", results[0].Registration.Name)
+	autopilot.Equals(t, 2, len(results[0].Registration.TagAssigns))
+}
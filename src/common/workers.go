@@ -0,0 +1,112 @@
+package common
+
+import "sync"
+
+// aliasRouter pins the aliases of every registration that is queued or running to a single worker so that
+// registrations which share any alias are always processed sequentially by the same worker.
+type aliasRouter struct {
+	mutex    sync.Mutex
+	drained  *sync.Cond
+	workers  int
+	next     int
+	inflight map[string]*aliasWork
+}
+
+// aliasWork is the worker an alias is pinned to and the number of its registrations queued or running there
+type aliasWork struct {
+	worker int
+	count  int
+}
+
+func newAliasRouter(workers int) *aliasRouter {
+	router := &aliasRouter{
+		workers:  workers,
+		inflight: map[string]*aliasWork{},
+	}
+	router.drained = sync.NewCond(&router.mutex)
+	return router
+}
+
+// acquire returns the worker for the registration's aliases and pins them to it until release.  If any alias is
+// pinned the registration goes to that worker, otherwise workers are handed out round-robin.  A registration
+// linking aliases pinned to different workers waits until all but one of those workers are done with them.
+func (r *aliasRouter) acquire(aliases []string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var workers map[int]bool
+	for {
+		workers = map[int]bool{}
+		for _, alias := range aliases {
+			if work, ok := r.inflight[alias]; ok {
+				workers[work.worker] = true
+			}
+		}
+		if len(workers) <= 1 {
+			break
+		}
+		r.drained.Wait()
+	}
+	worker := -1
+	for pinned := range workers {
+		worker = pinned
+	}
+	if worker < 0 {
+		worker = r.next
+		r.next = (r.next + 1) % r.workers
+	}
+	for _, alias := range aliases {
+		work, ok := r.inflight[alias]
+		if !ok {
+			work = &aliasWork{worker: worker}
+			r.inflight[alias] = work
+		}
+		work.count++
+	}
+	return worker
+}
+
+// release unpins the aliases of a registration once its worker is done with it
+func (r *aliasRouter) release(aliases []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, alias := range aliases {
+		if work, ok := r.inflight[alias]; ok {
+			if work.count--; work.count <= 0 {
+				delete(r.inflight, alias)
+			}
+		}
+	}
+	r.drained.Broadcast()
+}
+
+// RunWorkers drains the queue with N workers calling handler for every registration and blocks until the queue is closed
+// and all workers are finished.  Registrations sharing an alias are always handled by the same worker.
+func RunWorkers(workers int, queue <-chan SourcedRegistration, handler func(worker int, registration SourcedRegistration)) {
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		router   = newAliasRouter(workers)
+		channels = make([]chan SourcedRegistration, workers)
+	)
+	for i := range channels {
+		channels[i] = make(chan SourcedRegistration, 1)
+		wg.Add(1)
+		go func(worker int, work <-chan SourcedRegistration) {
+			defer wg.Done()
+			for registration := range work {
+				queueDepth.Dec()
+				handler(worker, registration)
+				router.release(registration.Aliases)
+			}
+		}(i, channels[i])
+	}
+	for registration := range queue {
+		channels[router.acquire(registration.Aliases)] <- registration
+	}
+	for _, work := range channels {
+		close(work)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,108 @@
+package common_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opslevel/kubectl-opslevel/common"
+	opslevel_jq_parser "github.com/opslevel/opslevel-jq-parser/v2024"
+	"github.com/rocktavious/autopilot/v2023"
+)
+
+func TestRunWorkers(t *testing.T) {
+	// Arrange
+	registrations := []opslevel_jq_parser.ServiceRegistration{
+		{Name: "a1", Aliases: []string{"a"}},
+		{Name: "b1", Aliases: []string{"b"}},
+		{Name: "c1", Aliases: []string{"c"}},
+		{Name: "a2", Aliases: []string{"k8s:a", "a"}},
+		{Name: "b2", Aliases: []string{"b"}},
+		{Name: "a3", Aliases: []string{"k8s:a"}},
+		{Name: "d1", Aliases: []string{"d"}},
+	}
+	queue := make(chan common.SourcedRegistration, len(registrations))
+	for _, registration := range registrations {
+		queue <- common.SourcedRegistration{ServiceRegistration: registration}
+	}
+	close(queue)
+
+	var (
+		mutex     sync.Mutex
+		handledBy = map[string]int{}
+		running   = map[string]bool{}
+		overlap   = false
+	)
+
+	// Act
+	common.RunWorkers(3, queue, func(worker int, registration common.SourcedRegistration) {
+		mutex.Lock()
+		handledBy[registration.Name] = worker
+		for _, alias := range registration.Aliases {
+			overlap = overlap || running[alias]
+			running[alias] = true
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		for _, alias := range registration.Aliases {
+			delete(running, alias)
+		}
+		mutex.Unlock()
+	})
+
+	// Assert
+	autopilot.Equals(t, len(registrations), len(handledBy))
+	autopilot.Equals(t, false, overlap)
+}
+
+func TestRunWorkersMinimumOfOne(t *testing.T) {
+	// Arrange
+	queue := make(chan common.SourcedRegistration, 2)
+	queue <- common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "a", Aliases: []string{"a"}}}
+	queue <- common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "b", Aliases: []string{"b"}}}
+	close(queue)
+	count := 0
+
+	// Act
+	common.RunWorkers(0, queue, func(worker int, registration common.SourcedRegistration) {
+		autopilot.Equals(t, 0, worker)
+		count++
+	})
+
+	// Assert
+	autopilot.Equals(t, 2, count)
+}
+
+func TestRunWorkersWaitsForLinkedAliases(t *testing.T) {
+	// Arrange
+	queue := make(chan common.SourcedRegistration, 3)
+	queue <- common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "a", Aliases: []string{"a"}}}
+	queue <- common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "b", Aliases: []string{"b"}}}
+	queue <- common.SourcedRegistration{ServiceRegistration: opslevel_jq_parser.ServiceRegistration{Name: "ab", Aliases: []string{"a", "b"}}}
+	close(queue)
+	var (
+		mutex   sync.Mutex
+		running = map[string]bool{}
+		overlap = false
+	)
+
+	// Act
+	common.RunWorkers(2, queue, func(worker int, registration common.SourcedRegistration) {
+		mutex.Lock()
+		for _, alias := range registration.Aliases {
+			overlap = overlap || running[alias]
+			running[alias] = true
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		for _, alias := range registration.Aliases {
+			delete(running, alias)
+		}
+		mutex.Unlock()
+	})
+
+	// Assert
+	autopilot.Equals(t, false, overlap)
+}